@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// certificate is subset of "ki certs check --json" output.
+type certificate struct {
+	Name     string    `json:"name"`
+	NotAfter time.Time `json:"notAfter"`
+	Expiring bool      `json:"expiring"`
+}
+
+// checkCerts runs "ki certs check" over SSH on control plane nodes, failing
+// if any certificate expires within window.
+func checkCerts(ctx context.Context, client *hcloud.Client, sshUser string, window time.Duration) error {
+	servers, err := client.Server.All(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get servers")
+	}
+	var expiring []string
+	for _, s := range servers {
+		// See main.tf for server names.
+		if !strings.HasPrefix(s.Name, "control-plane-node") {
+			continue
+		}
+		ip := s.PublicNet.IPv4.IP.String()
+		fmt.Println("checking certificates on", s.Name, ip)
+		cmd := exec.CommandContext(ctx, "ssh",
+			"-o", "StrictHostKeyChecking=accept-new",
+			"-o", "BatchMode=yes",
+			sshUser+"@"+ip,
+			"sudo", "ki", "certs", "check", "--json", "--window", window.String(),
+		)
+		cmd.Stderr = os.Stderr
+		// Exit code is non-zero if any certificate is expiring, output
+		// is still printed.
+		out, runErr := cmd.Output()
+		var certs []certificate
+		if err := json.Unmarshal(out, &certs); err != nil {
+			if runErr != nil {
+				return errors.Wrapf(runErr, "ki certs check on %s", s.Name)
+			}
+			return errors.Wrapf(err, "unmarshal output of %s", s.Name)
+		}
+		for _, c := range certs {
+			if c.Expiring {
+				expiring = append(expiring, fmt.Sprintf("%s: %s expires %s", s.Name, c.Name, c.NotAfter.Format(time.RFC3339)))
+			}
+		}
+	}
+	if len(expiring) > 0 {
+		return errors.Errorf("certificates expire within %s:\n%s", window, strings.Join(expiring, "\n"))
+	}
+	fmt.Println("certificates are valid for at least", window)
+	return nil
+}
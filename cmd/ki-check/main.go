@@ -13,17 +13,36 @@ import (
 
 	"github.com/go-faster/errors"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/ernado/ki/internal/config"
 )
 
 func run() (rerr error) {
 	var arg struct {
-		Cleanup bool
-		Timeout time.Duration
+		Cleanup      bool
+		Timeout      time.Duration
+		Config       string
+		SkipCerts    bool
+		SSHUser      string
+		ExpiryWindow time.Duration
 	}
+	flag.StringVar(&arg.Config, "config", config.LocalPath, "cluster spec, defaults are used if missing")
 	flag.BoolVar(&arg.Cleanup, "cleanup", false, "destroy terraform resources")
 	flag.DurationVar(&arg.Timeout, "timeout", 10*time.Minute, "timeout for checking the load balancer")
+	flag.BoolVar(&arg.SkipCerts, "skip-certs", false, "skip certificate expiry check on control plane nodes")
+	flag.StringVar(&arg.SSHUser, "ssh-user", "cluster", "SSH user with passwordless sudo on control plane nodes")
+	flag.DurationVar(&arg.ExpiryWindow, "cert-expiry-window", 0, "fail if certificate expires within window, certs.expiryWindow by default")
 	flag.Parse()
 
+	cfg, err := config.Load(arg.Config)
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+
+	if arg.ExpiryWindow == 0 {
+		arg.ExpiryWindow = time.Duration(cfg.Certs.ExpiryWindow)
+	}
+
 	if arg.Cleanup {
 		defer func() {
 			cmd := exec.Command("terraform", "destroy", "-auto-approve", "-var-file", ".tfvars")
@@ -48,6 +67,9 @@ func run() (rerr error) {
 
 	var pingURL string
 	for _, lb := range loadBalancers {
+		if lb.Name != cfg.Hetzner.LoadBalancer {
+			continue
+		}
 		ipAddr := lb.PublicNet.IPv4.IP.String()
 		u := &url.URL{
 			Scheme: "http",
@@ -57,7 +79,7 @@ func run() (rerr error) {
 		break
 	}
 	if pingURL == "" {
-		return errors.New("no load balancer found")
+		return errors.Errorf("load balancer %q not found", cfg.Hetzner.LoadBalancer)
 	}
 
 	ticker := time.NewTicker(1 * time.Second)
@@ -77,12 +99,18 @@ func run() (rerr error) {
 		_ = resp.Body.Close()
 		if resp.StatusCode == http.StatusOK {
 			fmt.Println("ping succeeded", time.Since(start))
-			return nil
+			break
 		} else {
 			fmt.Println("ping failed:", resp.Status)
 		}
 	}
 
+	if arg.SkipCerts {
+		return nil
+	}
+	if err := checkCerts(ctx, client, arg.SSHUser, arg.ExpiryWindow); err != nil {
+		return errors.Wrap(err, "check certificates")
+	}
 	return nil
 }
 
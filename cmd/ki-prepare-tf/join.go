@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/pki"
+)
+
+// Join credentials are kept in terraform directory, so nodes created
+// later by terraform can still join.
+const (
+	joinSecretFile             = "join-secret"
+	controlPlaneJoinSecretFile = "control-plane-join-secret"
+	joinCertFile               = "join.crt"
+	joinKeyFile                = "join.key"
+	caCertFile                 = "ca.crt"
+	caKeyFile                  = "ca.key"
+	bootstrapTokenFile         = "bootstrap-token"
+)
+
+// joinCertValidity is long enough to outlive cluster, certificate is
+// trusted only by its pinned public key.
+const joinCertValidity = 10 * 365 * 24 * time.Hour
+
+// caValidity is same as validity of CA generated by kubeadm.
+const caValidity = 10 * 365 * 24 * time.Hour
+
+// joinCredentials authenticate nodes to join server and join server to nodes.
+type joinCredentials struct {
+	Secret string
+	// ControlPlaneSecret is given only to control plane nodes, it allows
+	// getting certificate key for control plane join.
+	ControlPlaneSecret string
+	Cert               []byte
+	Key                []byte
+	Fingerprint        string
+
+	// Cluster CA and bootstrap token, so workers can join without join server.
+	CACert         []byte
+	CAKey          []byte
+	CACertHash     string
+	BootstrapToken string
+}
+
+// generateMissing writes files generated by fn, if any of them is missing.
+func generateMissing(names []string, fn func() ([][]byte, error)) error {
+	missing := false
+	for _, name := range names {
+		if _, err := os.Stat(name); err != nil {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+	data, err := fn()
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		if err := os.WriteFile(name, data[i], 0600); err != nil {
+			return errors.Wrapf(err, "write %s", name)
+		}
+	}
+	return nil
+}
+
+// loadJoinCredentials reads join credentials, generating missing ones.
+func loadJoinCredentials(controlPlaneIP string) (*joinCredentials, error) {
+	for _, name := range []string{joinSecretFile, controlPlaneJoinSecretFile} {
+		if err := generateMissing([]string{name}, func() ([][]byte, error) {
+			secret, err := pki.NewSecret()
+			if err != nil {
+				return nil, errors.Wrap(err, "generate secret")
+			}
+			return [][]byte{[]byte(secret)}, nil
+		}); err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+	}
+	if err := generateMissing([]string{joinCertFile, joinKeyFile}, func() ([][]byte, error) {
+		cert, key, err := pki.NewServingCert("ki-join", []string{controlPlaneIP}, joinCertValidity)
+		if err != nil {
+			return nil, errors.Wrap(err, "generate certificate")
+		}
+		return [][]byte{cert, key}, nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "join certificate")
+	}
+	if err := generateMissing([]string{caCertFile, caKeyFile}, func() ([][]byte, error) {
+		// Same common name as CA generated by kubeadm.
+		cert, key, err := pki.NewCA("kubernetes", caValidity)
+		if err != nil {
+			return nil, errors.Wrap(err, "generate CA")
+		}
+		return [][]byte{cert, key}, nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "cluster CA")
+	}
+	if err := generateMissing([]string{bootstrapTokenFile}, func() ([][]byte, error) {
+		token, err := pki.NewBootstrapToken()
+		if err != nil {
+			return nil, errors.Wrap(err, "generate token")
+		}
+		return [][]byte{[]byte(token)}, nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "bootstrap token")
+	}
+
+	var c joinCredentials
+	secret, err := os.ReadFile(joinSecretFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read secret")
+	}
+	c.Secret = string(secret)
+	controlPlaneSecret, err := os.ReadFile(controlPlaneJoinSecretFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read control plane secret")
+	}
+	c.ControlPlaneSecret = string(controlPlaneSecret)
+	if c.Cert, err = os.ReadFile(joinCertFile); err != nil {
+		return nil, errors.Wrap(err, "read certificate")
+	}
+	if c.Key, err = os.ReadFile(joinKeyFile); err != nil {
+		return nil, errors.Wrap(err, "read key")
+	}
+	cert, err := pki.ParseCertificate(c.Cert)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse certificate")
+	}
+	c.Fingerprint = pki.Fingerprint(cert)
+
+	if c.CACert, err = os.ReadFile(caCertFile); err != nil {
+		return nil, errors.Wrap(err, "read CA certificate")
+	}
+	if c.CAKey, err = os.ReadFile(caKeyFile); err != nil {
+		return nil, errors.Wrap(err, "read CA key")
+	}
+	ca, err := pki.ParseCertificate(c.CACert)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse CA certificate")
+	}
+	c.CACertHash = pki.Fingerprint(ca)
+	token, err := os.ReadFile(bootstrapTokenFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read bootstrap token")
+	}
+	c.BootstrapToken = string(token)
+	return &c, nil
+}
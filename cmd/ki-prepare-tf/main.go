@@ -6,13 +6,16 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"strings"
 
 	hcl "github.com/alecthomas/hcl/v2"
 	"github.com/go-faster/errors"
 	"gopkg.in/yaml.v3"
+
+	"github.com/ernado/ki/internal/config"
 )
 
 func marshal(v interface{}) ([]byte, error) {
@@ -26,67 +29,130 @@ func marshal(v interface{}) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// installKi returns commands that install ki release for architecture.
+func installKi(version, arch string) []string {
+	archive := "ki-linux-" + arch + ".tar.gz"
+	return []string{
+		"wget https://github.com/ernado/ki/releases/download/" + version + "/" + archive,
+		"tar -xvf " + archive,
+		"mv ki /usr/local/bin/ki",
+	}
+}
+
+// rePseudoVersion matches go module pseudo-versions, that are not releases.
+var rePseudoVersion = regexp.MustCompile(`\d{14}-[0-9a-f]{12}$`)
+
+// releaseVersion returns version of ki-prepare-tf if it is installed from
+// release, like "go install github.com/ernado/ki/cmd/ki-prepare-tf@v0.9.0".
+//
+// Nodes run ki of same version, so cloud-init matches ki command line.
+func releaseVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	v := info.Main.Version
+	if v == "" || v == "(devel)" || rePseudoVersion.MatchString(v) {
+		return ""
+	}
+	return v
+}
+
+// defaultControlPlaneEndpoint is private IP of control plane load balancer,
+// used if there are multiple control plane nodes.
+const defaultControlPlaneEndpoint = "10.0.1.254"
+
 //go:embed main.tf
 var mainTerraform string
 
 func run() error {
 	var arg struct {
+		Config               string
 		Token                string
 		PublicKeyPath        string
 		WorkerNodeType       string
 		WorkerNodeCount      int
 		SSHKeyName           string
 		ControlPlaneNodeType string
+		ControlPlaneCount    int
 		Location             string
+		Image                string
+		KiVersion            string
 	}
 	var defaultPublicKey string
 	if home, err := os.UserHomeDir(); err == nil {
 		defaultPublicKey = filepath.Join(home, ".ssh", "id_ed25519.pub")
 	}
+	def := config.Default()
+	flag.StringVar(&arg.Config, "config", config.LocalPath, "Cluster spec, defaults are used if missing")
 	flag.StringVar(&arg.Token, "token", os.Getenv("HETZNER_TOKEN"), "Hetzner token ($HETZNER_TOKEN)")
 	flag.StringVar(&arg.PublicKeyPath, "pubkey", defaultPublicKey, "Host public key")
-	flag.StringVar(&arg.WorkerNodeType, "worker-type", "cpx11", "Worker node type")
-	flag.IntVar(&arg.WorkerNodeCount, "worker-count", 1, "Worker node count")
-	flag.StringVar(&arg.ControlPlaneNodeType, "control-plane-type", "cpx11", "Control plane node type")
-	flag.StringVar(&arg.Location, "location", "hel1", "Location")
-	flag.StringVar(&arg.SSHKeyName, "ssh-key-name", "ki", "SSH key name")
+	flag.StringVar(&arg.WorkerNodeType, "worker-type", def.Workers.ServerType, "Worker node type, Ampere cax* types are arm64")
+	flag.IntVar(&arg.WorkerNodeCount, "worker-count", def.Workers.Count, "Worker node count")
+	flag.StringVar(&arg.ControlPlaneNodeType, "control-plane-type", def.ControlPlane.ServerType, "Control plane node type, Ampere cax* types are arm64")
+	flag.IntVar(&arg.ControlPlaneCount, "control-plane-count", def.ControlPlane.Count, "Control plane node count, more than one for HA")
+	flag.StringVar(&arg.Location, "location", def.Hetzner.Location, "Location")
+	flag.StringVar(&arg.Image, "image", def.Hetzner.Image, "Server image")
+	flag.StringVar(&arg.SSHKeyName, "ssh-key-name", def.Hetzner.SSHKeyName, "SSH key name")
+	flag.StringVar(&arg.KiVersion, "ki-version", releaseVersion(), "ki release installed on nodes, version of ki-prepare-tf by default")
 	flag.Parse()
 
 	if arg.Token == "" {
 		return errors.New("no token provided")
 	}
-
-	fmt.Println("> Preparing terraform in current directory")
-
-	fmt.Println("> Writing main.tf")
-	if err := os.WriteFile("main.tf", []byte(mainTerraform), 0600); err != nil {
-		return errors.Wrap(err, "write main.tf")
+	if arg.KiVersion == "" {
+		return errors.New("ki release is unknown for development build, set --ki-version")
 	}
 
-	fmt.Println("> Checking for shh keys")
-	if _, stat := os.Stat("worker_ed25519"); stat != nil {
-		fmt.Println("> Generating ed25519 key")
-		cmd := exec.Command("ssh-keygen",
-			"-t", "ed25519",
-			"-f", "worker_ed25519",
-			"-N", "",
-		)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return errors.Wrap(err, "ssh-keygen")
+	cfg, err := config.Load(arg.Config)
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+	// Explicitly set flags override cluster spec.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "worker-type":
+			cfg.Workers.ServerType = arg.WorkerNodeType
+		case "worker-count":
+			cfg.Workers.Count = arg.WorkerNodeCount
+		case "control-plane-type":
+			cfg.ControlPlane.ServerType = arg.ControlPlaneNodeType
+		case "control-plane-count":
+			cfg.ControlPlane.Count = arg.ControlPlaneCount
+		case "location":
+			cfg.Hetzner.Location = arg.Location
+		case "ssh-key-name":
+			cfg.Hetzner.SSHKeyName = arg.SSHKeyName
+		case "image":
+			cfg.Hetzner.Image = arg.Image
 		}
-	} else {
-		fmt.Println("> Key already exist")
+	})
+	if cfg.ControlPlane.Count > 1 && cfg.ControlPlane.Endpoint == "" {
+		// Private IP of control plane load balancer, see main.tf.
+		cfg.ControlPlane.Endpoint = defaultControlPlaneEndpoint
 	}
-
-	workerPublicKey, err := os.ReadFile("worker_ed25519.pub")
+	fmt.Println("> Checking for join credentials")
+	join, err := loadJoinCredentials(cfg.ControlPlane.InternalIP)
 	if err != nil {
-		return errors.Wrap(err, "read worker public key")
+		return errors.Wrap(err, "join credentials")
 	}
-	workerPrivateKey, err := os.ReadFile("worker_ed25519")
+	// Nodes pin join server certificate and cluster CA.
+	cfg.Join.Fingerprint = join.Fingerprint
+	cfg.Join.CACertHash = join.CACertHash
+	if err := cfg.Validate(); err != nil {
+		return errors.Wrap(err, "invalid config")
+	}
+	// Same spec is used by ki on nodes.
+	cfgData, err := cfg.Marshal()
 	if err != nil {
-		return errors.Wrap(err, "read worker private key")
+		return errors.Wrap(err, "marshal config")
+	}
+
+	fmt.Println("> Preparing terraform in current directory")
+
+	fmt.Println("> Writing main.tf")
+	if err := os.WriteFile("main.tf", []byte(mainTerraform), 0600); err != nil {
+		return errors.Wrap(err, "write main.tf")
 	}
 
 	hostPublicKey, err := os.ReadFile(arg.PublicKeyPath)
@@ -95,7 +161,6 @@ func run() error {
 	}
 
 	fmt.Println("> Generating cloud init script for worker")
-	kiLink := "https://github.com/ernado/ki/releases/download/v0.8.0/ki-linux-amd64.tar.gz"
 	cloudInitWorkerConfig := CloudConfig{
 		Packages: []string{"curl", "wget"},
 		Users: []User{
@@ -110,17 +175,24 @@ func run() error {
 		},
 		WriteFiles: []File{
 			{
-				Path:        "/root/.ssh/id_ed25519",
-				Content:     string(workerPrivateKey),
+				Path:        config.JoinSecretPath,
+				Content:     join.Secret,
+				Permissions: "0600",
+			},
+			{
+				Path:        config.BootstrapTokenPath,
+				Content:     join.BootstrapToken,
+				Permissions: "0600",
+			},
+			{
+				Path:        config.NodePath,
+				Content:     string(cfgData),
 				Permissions: "0600",
 			},
 		},
-		RunCmd: []string{
-			"wget " + kiLink,
-			"tar -xvf ki-linux-amd64.tar.gz",
-			"mv ki /usr/local/bin/ki",
-			"ki --install --join",
-		},
+		RunCmd: append(installKi(arg.KiVersion, config.ServerArch(cfg.Workers.ServerType)),
+			"ki install-service --join",
+		),
 	}
 	cloudInitWorkerData, err := marshal(cloudInitWorkerConfig)
 	if err != nil {
@@ -130,6 +202,36 @@ func run() error {
 		return errors.Wrap(err, "cloud init worker write")
 	}
 
+	fmt.Println("> Generating cloud init script for additional control plane nodes")
+	cloudInitControlPlaneJoinConfig := CloudConfig{
+		Packages: cloudInitWorkerConfig.Packages,
+		Users:    cloudInitWorkerConfig.Users,
+		// Control plane nodes always join with join server, so bootstrap
+		// token and worker secret are not needed.
+		WriteFiles: []File{
+			{
+				Path:        config.ControlPlaneJoinSecretPath,
+				Content:     join.ControlPlaneSecret,
+				Permissions: "0600",
+			},
+			{
+				Path:        config.NodePath,
+				Content:     string(cfgData),
+				Permissions: "0600",
+			},
+		},
+		RunCmd: append(installKi(arg.KiVersion, config.ServerArch(cfg.ControlPlane.ServerType)),
+			"ki install-service --join --control-plane",
+		),
+	}
+	cloudInitControlPlaneJoinData, err := marshal(cloudInitControlPlaneJoinConfig)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	if err := os.WriteFile("cloud-init-control-plane-join.yaml", cloudInitControlPlaneJoinData, 0600); err != nil {
+		return errors.Wrap(err, "cloud init control plane join write")
+	}
+
 	fmt.Println("> Generating cloud init script for control plane")
 	cloudInitControlPlaneConfig := CloudConfig{
 		Packages: []string{"curl", "wget"},
@@ -140,23 +242,71 @@ func run() error {
 				Shell: "/bin/bash",
 				SSHAuthorizedKeys: []string{
 					strings.TrimSpace(string(hostPublicKey)),
-					strings.TrimSpace(string(workerPublicKey)),
 				},
 			},
 		},
 		WriteFiles: []File{
 			{
-				Path:        "/root/.hcloud",
+				Path:        cfg.Hetzner.TokenPath,
 				Content:     arg.Token,
 				Permissions: "0600",
 			},
+			{
+				Path:        config.JoinSecretPath,
+				Content:     join.Secret,
+				Permissions: "0600",
+			},
+			{
+				Path:        config.ControlPlaneJoinSecretPath,
+				Content:     join.ControlPlaneSecret,
+				Permissions: "0600",
+			},
+			{
+				Path:        config.BootstrapTokenPath,
+				Content:     join.BootstrapToken,
+				Permissions: "0600",
+			},
+			{
+				Path:        config.JoinCertPath,
+				Content:     string(join.Cert),
+				Permissions: "0644",
+			},
+			{
+				Path:        config.JoinKeyPath,
+				Content:     string(join.Key),
+				Permissions: "0600",
+			},
+			{
+				Path:        config.CACertPath,
+				Content:     string(join.CACert),
+				Permissions: "0644",
+			},
+			{
+				Path:        config.CAKeyPath,
+				Content:     string(join.CAKey),
+				Permissions: "0600",
+			},
+			{
+				Path:        config.NodePath,
+				Content:     string(cfgData),
+				Permissions: "0600",
+			},
 		},
-		RunCmd: []string{
-			"wget " + kiLink,
-			"tar -xvf ki-linux-amd64.tar.gz",
-			"mv ki /usr/local/bin/ki",
-			"ki --install",
-		},
+		RunCmd: append(installKi(arg.KiVersion, config.ServerArch(cfg.ControlPlane.ServerType)),
+			"ki install-service",
+		),
+	}
+	if cfg.Backup.S3.Bucket != "" {
+		// Only first control plane node takes etcd snapshots.
+		accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if accessKey == "" || secretKey == "" {
+			return errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for backup")
+		}
+		cloudInitControlPlaneConfig.WriteFiles = append(cloudInitControlPlaneConfig.WriteFiles, File{
+			Path:        cfg.Backup.S3.CredentialsPath,
+			Content:     "AWS_ACCESS_KEY_ID=" + accessKey + "\nAWS_SECRET_ACCESS_KEY=" + secretKey + "\n",
+			Permissions: "0600",
+		})
 	}
 	cloudInitControlPlaneData, err := marshal(cloudInitControlPlaneConfig)
 	if err != nil {
@@ -169,20 +319,32 @@ func run() error {
 	fmt.Println("> Write .tfvars")
 	{
 		type Config struct {
-			Location         string `hcl:"location"`
-			WorkerType       string `hcl:"worker_type"`
-			WorkerCount      int    `hcl:"worker_count"`
-			SSHKeyName       string `hcl:"ssh_key_name"`
-			ControlPlaneType string `hcl:"control_plane_type"`
-			Token            string `hcl:"hcloud_token"`
+			Location             string `hcl:"location"`
+			WorkerType           string `hcl:"worker_type"`
+			WorkerCount          int    `hcl:"worker_count"`
+			SSHKeyName           string `hcl:"ssh_key_name"`
+			ControlPlaneType     string `hcl:"control_plane_type"`
+			Token                string `hcl:"hcloud_token"`
+			NetworkName          string `hcl:"network_name"`
+			LoadBalancerName     string `hcl:"load_balancer_name"`
+			ControlPlaneIP       string `hcl:"control_plane_ip"`
+			ControlPlaneCount    int    `hcl:"control_plane_count"`
+			ControlPlaneEndpoint string `hcl:"control_plane_endpoint"`
+			Image                string `hcl:"image"`
 		}
 		data, err := hcl.Marshal(&Config{
-			Location:         arg.Location,
-			WorkerType:       arg.WorkerNodeType,
-			WorkerCount:      arg.WorkerNodeCount,
-			ControlPlaneType: arg.ControlPlaneNodeType,
-			SSHKeyName:       arg.SSHKeyName,
-			Token:            arg.Token,
+			Location:             cfg.Hetzner.Location,
+			WorkerType:           cfg.Workers.ServerType,
+			WorkerCount:          cfg.Workers.Count,
+			ControlPlaneType:     cfg.ControlPlane.ServerType,
+			SSHKeyName:           cfg.Hetzner.SSHKeyName,
+			Token:                arg.Token,
+			NetworkName:          cfg.Hetzner.Network,
+			LoadBalancerName:     cfg.Hetzner.LoadBalancer,
+			ControlPlaneIP:       cfg.ControlPlane.InternalIP,
+			ControlPlaneCount:    cfg.ControlPlane.Count,
+			ControlPlaneEndpoint: cfg.ControlPlane.Endpoint,
+			Image:                cfg.Hetzner.Image,
 		})
 		if err != nil {
 			return errors.Wrap(err, "marshal tfvars")
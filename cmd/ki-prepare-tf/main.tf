@@ -39,13 +39,43 @@ variable "worker_count" {
   default = 1
 }
 
+variable "network_name" {
+  description = "Name of the private network"
+  default = "kubernetes-cluster"
+}
+
+variable "load_balancer_name" {
+  description = "Name of the load balancer"
+  default = "kubernetes-load-balancer"
+}
+
+variable "control_plane_ip" {
+  description = "Private IP of the control plane node"
+  default = "10.0.1.1"
+}
+
+variable "control_plane_count" {
+  description = "Number of control plane nodes, more than one for HA"
+  default = 1
+}
+
+variable "control_plane_endpoint" {
+  description = "Private IP of the control plane load balancer, used if control_plane_count > 1"
+  default = "10.0.1.254"
+}
+
+variable "image" {
+  description = "Server image, e.g. ubuntu-24.04 or debian-12"
+  default = "ubuntu-24.04"
+}
+
 # Configure the Hetzner Cloud Provider with your token
 provider "hcloud" {
   token = var.hcloud_token
 }
 
 resource "hcloud_network" "private_network" {
-  name     = "kubernetes-cluster"
+  name     = var.network_name
   ip_range = "10.0.0.0/16"
 }
 
@@ -58,7 +88,8 @@ resource "hcloud_network_subnet" "private_network_subnet" {
 
 resource "hcloud_server" "control-plane-node" {
   name        = "control-plane-node"
-  image       = "ubuntu-24.04"
+  labels      = { role = "control-plane" }
+  image       = var.image
   server_type = var.control_plane_type
   location    = var.location
   public_net {
@@ -69,7 +100,7 @@ resource "hcloud_server" "control-plane-node" {
     network_id = hcloud_network.private_network.id
     # IP Used by the control plane node, needs to be static
     # Here the worker nodes will use 10.0.1.1 to communicate with the control plane node
-    ip         = "10.0.1.1"
+    ip         = var.control_plane_ip
   }
   user_data = file("${path.module}/cloud-init.yaml")
   ssh_keys = [ var.ssh_key_name ]
@@ -79,12 +110,73 @@ resource "hcloud_server" "control-plane-node" {
   depends_on = [hcloud_network_subnet.private_network_subnet]
 }
 
+resource "hcloud_server" "control-plane-nodes" {
+  count = var.control_plane_count - 1
+
+  # Additional control plane nodes, joined to the first one.
+  name        = "control-plane-node-${count.index + 1}"
+  labels      = { role = "control-plane" }
+  image       = var.image
+  server_type = var.control_plane_type
+  location    = var.location
+  public_net {
+    ipv4_enabled = true
+    ipv6_enabled = true
+  }
+  network {
+    network_id = hcloud_network.private_network.id
+  }
+  user_data = file("${path.module}/cloud-init-control-plane-join.yaml")
+  ssh_keys = [ var.ssh_key_name ]
+
+  depends_on = [hcloud_network_subnet.private_network_subnet, hcloud_server.control-plane-node]
+}
+
+# Load balancer for API server, serves as stable control plane endpoint.
+resource "hcloud_load_balancer" "control_plane" {
+  count              = var.control_plane_count > 1 ? 1 : 0
+  name               = "${var.load_balancer_name}-control-plane"
+  load_balancer_type = "lb11"
+  location           = var.location
+}
+
+resource "hcloud_load_balancer_network" "control_plane" {
+  count            = var.control_plane_count > 1 ? 1 : 0
+  load_balancer_id = hcloud_load_balancer.control_plane[0].id
+  network_id       = hcloud_network.private_network.id
+  ip               = var.control_plane_endpoint
+
+  depends_on = [hcloud_network_subnet.private_network_subnet]
+}
+
+resource "hcloud_load_balancer_service" "control_plane" {
+  count            = var.control_plane_count > 1 ? 1 : 0
+  load_balancer_id = hcloud_load_balancer.control_plane[0].id
+  protocol         = "tcp"
+  listen_port      = 6443
+  destination_port = 6443
+}
+
+resource "hcloud_load_balancer_target" "control_plane" {
+  for_each = var.control_plane_count > 1 ? {
+    for idx, server in concat([hcloud_server.control-plane-node], hcloud_server.control-plane-nodes) : idx => server
+  } : {}
+
+  type             = "server"
+  load_balancer_id = hcloud_load_balancer.control_plane[0].id
+  server_id        = each.value.id
+  use_private_ip   = true
+
+  depends_on = [hcloud_load_balancer_network.control_plane]
+}
+
 resource "hcloud_server" "worker-nodes" {
   count = var.worker_count
 
   # The name will be worker-node-0, worker-node-1, worker-node-2...
   name        = "worker-node-${count.index}"
-  image       = "ubuntu-24.04"
+  labels      = { role = "worker" }
+  image       = var.image
   server_type = var.worker_type
   location    = var.location
   public_net {
@@ -101,7 +193,7 @@ resource "hcloud_server" "worker-nodes" {
 }
 
 resource "hcloud_load_balancer" "load_balancer" {
-  name               = "kubernetes-load-balancer"
+  name               = var.load_balancer_name
   load_balancer_type = "lb11"
   location           = var.location
 
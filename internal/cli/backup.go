@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+	"github.com/ernado/ki/internal/install"
+)
+
+func runBackupEtcd(ctx context.Context, args []string) error {
+	var (
+		lf         logFlags
+		cfgPath    string
+		dryRun     bool
+		kubeconfig string
+		nodeName   string
+		output     string
+	)
+	fs := newFlagSet("backup etcd", "Save etcd snapshot and upload it to S3")
+	lf.register(fs)
+	fs.StringVar(&cfgPath, "config", config.NodePath, "path to cluster spec, defaults are used if missing")
+	fs.BoolVar(&dryRun, "dry-run", false, "print commands instead of saving snapshot")
+	fs.StringVar(&kubeconfig, "kubeconfig", "/etc/kubernetes/admin.conf", "kubeconfig with admin access")
+	fs.StringVar(&nodeName, "node", "", "control plane node name, hostname by default")
+	fs.StringVar(&output, "output", "", "save snapshot to file instead of uploading it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := lf.setup(); err != nil {
+		return err
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+	if nodeName == "" {
+		name, err := install.NodeName()
+		if err != nil {
+			return errors.Wrap(err, "node name")
+		}
+		nodeName = name
+	}
+	nf := nodeFlags{DryRun: dryRun}
+	in, done, err := nf.installer()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := in.BackupEtcd(ctx, install.EtcdBackupOptions{
+		NodeName:    nodeName,
+		Kubeconfig:  kubeconfig,
+		ClusterName: cfg.Kubernetes.ClusterName,
+		S3:          cfg.Backup.S3,
+		Keep:        cfg.Backup.Keep,
+		Output:      output,
+	}); err != nil {
+		return errors.Wrap(err, "backup")
+	}
+	return nil
+}
+
+func runRestoreEtcd(ctx context.Context, args []string) error {
+	var (
+		lf      logFlags
+		cfgPath string
+		dryRun  bool
+	)
+	fs := newFlagSet("restore etcd", "Restore etcd of control plane node from snapshot")
+	lf.register(fs)
+	fs.StringVar(&cfgPath, "config", config.NodePath, "path to cluster spec, defaults are used if missing")
+	fs.BoolVar(&dryRun, "dry-run", false, "print commands instead of changing node")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: ki restore etcd [flags] <snapshot file or key>")
+	}
+	if err := lf.setup(); err != nil {
+		return err
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+	nf := nodeFlags{DryRun: dryRun}
+	in, done, err := nf.installer()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := in.RestoreEtcd(ctx, install.EtcdRestoreOptions{
+		Snapshot:          fs.Arg(0),
+		S3:                cfg.Backup.S3,
+		ControlPlaneCount: cfg.ControlPlane.Count,
+	}); err != nil {
+		return errors.Wrap(err, "restore")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+	"github.com/ernado/ki/internal/install"
+)
+
+func runCertsCheck(_ context.Context, args []string) error {
+	var (
+		asJSON  bool
+		cfgPath string
+		window  time.Duration
+	)
+	fs := newFlagSet("certs check", "Show expiry of control plane certificates")
+	fs.BoolVar(&asJSON, "json", false, "print certificates as JSON")
+	fs.StringVar(&cfgPath, "config", config.NodePath, "path to cluster spec, defaults are used if missing")
+	fs.DurationVar(&window, "window", 0, "expiry window, certs.expiryWindow from cluster spec by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+	if window == 0 {
+		window = time.Duration(cfg.Certs.ExpiryWindow)
+	}
+	certs, err := install.New(install.NewExecRunner()).Certificates(window)
+	if err != nil {
+		return errors.Wrap(err, "certificates")
+	}
+	expiring := 0
+	for _, c := range certs {
+		if c.Expiring {
+			expiring++
+		}
+	}
+	if asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(certs); err != nil {
+			return errors.Wrap(err, "encode")
+		}
+	} else {
+		for _, c := range certs {
+			status := "OK"
+			if c.Expiring {
+				status = "EXPIRING"
+			}
+			name := c.Name
+			if c.CA {
+				name += " (CA)"
+			}
+			residual := time.Until(c.NotAfter).Truncate(time.Hour)
+			fmt.Printf("[%s] %-45s %s (%dd)\n", status, name, c.NotAfter.Format(time.RFC3339), int(residual.Hours()/24))
+		}
+	}
+	if expiring > 0 {
+		return errors.Errorf("%d certificates expire within %s, run ki certs renew", expiring, window)
+	}
+	return nil
+}
+
+func runCertsRenew(ctx context.Context, args []string) error {
+	var (
+		lf     logFlags
+		dryRun bool
+	)
+	fs := newFlagSet("certs renew", "Renew control plane certificates and restart control plane")
+	lf.register(fs)
+	fs.BoolVar(&dryRun, "dry-run", false, "print commands instead of changing node")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := lf.setup(); err != nil {
+		return err
+	}
+	nf := nodeFlags{DryRun: dryRun}
+	in, done, err := nf.installer()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := in.RenewCertificates(ctx); err != nil {
+		return errors.Wrap(err, "renew")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package cli implements ki command line interface.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-faster/errors"
+)
+
+// Build is version information set by goreleaser.
+type Build struct {
+	Version string
+	Commit  string
+	Date    string
+}
+
+type command struct {
+	Name  string
+	Short string
+	Run   func(ctx context.Context, args []string) error
+}
+
+func commands(b Build) []command {
+	return []command{
+		{Name: "init", Short: "Install node and initialize cluster as control plane", Run: runInit},
+		{Name: "join", Short: "Install node and join cluster as worker or control plane", Run: runJoin},
+		{Name: "join-params", Short: "Print join parameters with fresh token", Run: runJoinParams},
+		{Name: "serve-join", Short: "Serve join parameters to nodes with join secret", Run: runServeJoin},
+		{Name: "install-service", Short: "Install ki as systemd service that runs init or join", Run: runInstallService},
+		{Name: "preflight", Short: "Check that node is suitable for install", Run: runPreflight},
+		{Name: "status", Short: "Show node status and install progress", Run: runStatus},
+		{Name: "reset", Short: "Undo changes made by ki to node", Run: runReset},
+		{Name: "upgrade", Short: "Upgrade kubernetes on node", Run: runUpgrade},
+		{Name: "node", Short: "Manage cluster nodes", Run: group("node", []command{
+			{Name: "remove", Short: "Drain and delete node from cluster and Hetzner Cloud", Run: runNodeRemove},
+		})},
+		{Name: "certs", Short: "Check and renew control plane certificates", Run: group("certs", []command{
+			{Name: "check", Short: "Show expiry of control plane certificates", Run: runCertsCheck},
+			{Name: "renew", Short: "Renew control plane certificates and restart control plane", Run: runCertsRenew},
+		})},
+		{Name: "backup", Short: "Back up cluster state", Run: group("backup", []command{
+			{Name: "etcd", Short: "Save etcd snapshot and upload it to S3", Run: runBackupEtcd},
+		})},
+		{Name: "restore", Short: "Restore cluster state from backup", Run: group("restore", []command{
+			{Name: "etcd", Short: "Restore etcd of control plane node from snapshot", Run: runRestoreEtcd},
+		})},
+		{Name: "version", Short: "Print ki version", Run: func(_ context.Context, args []string) error { return runVersion(b, args) }},
+	}
+}
+
+func usage(w io.Writer, cmds []command) {
+	_, _ = fmt.Fprintln(w, "ki is opinionated Kubernetes installer for Hetzner Cloud.")
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "Usage:")
+	_, _ = fmt.Fprintln(w, "  ki <command> [flags]")
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "Commands:")
+	for _, c := range cmds {
+		_, _ = fmt.Fprintf(w, "  %-16s %s\n", c.Name, c.Short)
+	}
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, `Run "ki <command> -h" for command flags.`)
+}
+
+// group returns command that runs one of subcommands, like "ki node remove".
+func group(name string, cmds []command) func(ctx context.Context, args []string) error {
+	printUsage := func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "Usage:\n  ki %s <command> [flags]\n\nCommands:\n", name)
+		for _, c := range cmds {
+			_, _ = fmt.Fprintf(w, "  %-16s %s\n", c.Name, c.Short)
+		}
+	}
+	return func(ctx context.Context, args []string) error {
+		if len(args) == 0 {
+			printUsage(os.Stderr)
+			return errors.Errorf("ki %s: command is required", name)
+		}
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			printUsage(os.Stdout)
+			return nil
+		}
+		for _, c := range cmds {
+			if c.Name == args[0] {
+				return c.Run(ctx, args[1:])
+			}
+		}
+		printUsage(os.Stderr)
+		return errors.Errorf("unknown command %q", name+" "+args[0])
+	}
+}
+
+// newFlagSet creates flag set for subcommand with usage that includes description.
+func newFlagSet(name, short string) *flag.FlagSet {
+	fs := flag.NewFlagSet("ki "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		_, _ = fmt.Fprintf(out, "%s.\n\nUsage:\n  ki %s [flags]\n\nFlags:\n", short, name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Run runs ki with command line arguments, excluding program name.
+//
+// Install is interrupted when ctx is done.
+func Run(ctx context.Context, b Build, args []string) error {
+	cmds := commands(b)
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			usage(os.Stdout, cmds)
+			return nil
+		}
+		// Legacy flags, used by existing cloud-init payloads and ki.conf.
+		return runLegacy(ctx, args)
+	}
+	if args[0] == "help" {
+		usage(os.Stdout, cmds)
+		return nil
+	}
+	for _, c := range cmds {
+		if c.Name != args[0] {
+			continue
+		}
+		if err := c.Run(ctx, args[1:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+	usage(os.Stderr, cmds)
+	return errors.Errorf("unknown command %q", args[0])
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+	"github.com/ernado/ki/internal/install"
+)
+
+// runJoinParams prints join parameters with fresh token, same as served
+// by join server.
+func runJoinParams(ctx context.Context, args []string) error {
+	var (
+		lf           logFlags
+		controlPlane bool
+	)
+	fs := newFlagSet("join-params", "Print join parameters with fresh token as JSON")
+	lf.register(fs)
+	fs.BoolVar(&controlPlane, "control-plane", false, "include certificate key for control plane join")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := lf.setup(); err != nil {
+		return err
+	}
+	// Stdout is reserved for parameters.
+	in := install.New(&install.ExecRunner{Stdout: os.Stderr, Stderr: os.Stderr})
+	params, err := in.JoinParams(ctx, install.JoinParamsOptions{ControlPlane: controlPlane})
+	if err != nil {
+		return errors.Wrap(err, "join params")
+	}
+	return json.NewEncoder(os.Stdout).Encode(params)
+}
+
+// runServeJoin runs join server on control plane node, see ki-join.service.
+func runServeJoin(ctx context.Context, args []string) error {
+	var (
+		lf      logFlags
+		cfgPath string
+		addr    string
+	)
+	fs := newFlagSet("serve-join", "Serve join parameters to nodes with join secret")
+	lf.register(fs)
+	fs.StringVar(&cfgPath, "config", config.NodePath, "path to cluster spec, defaults are used if missing")
+	fs.StringVar(&addr, "addr", "", "listen address, private IP of control plane node and join.port by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := lf.setup(); err != nil {
+		return err
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+	if addr == "" {
+		addr = cfg.JoinServerAddr()
+	}
+	in := install.New(&install.ExecRunner{Stdout: os.Stderr, Stderr: os.Stderr})
+	return in.ServeJoin(ctx, install.ServeJoinOptions{
+		Addr:                   addr,
+		SecretPath:             config.JoinSecretPath,
+		ControlPlaneSecretPath: config.ControlPlaneJoinSecretPath,
+		CertPath:               config.JoinCertPath,
+		KeyPath:                config.JoinKeyPath,
+	})
+}
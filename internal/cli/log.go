@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/go-faster/errors"
+)
+
+// logFlags configure structured logging.
+type logFlags struct {
+	Format string
+	Level  string
+}
+
+func (f *logFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Format, "log-format", "text", "log format: text or json")
+	fs.StringVar(&f.Level, "log-level", "info", "log level: debug, info, warn or error")
+}
+
+// setup sets default slog logger that writes to stderr.
+func (f *logFlags) setup() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(f.Level)); err != nil {
+		return errors.Wrap(err, "log level")
+	}
+	opt := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch f.Format {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opt)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opt)
+	default:
+		return errors.Errorf("unknown log format %q", f.Format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
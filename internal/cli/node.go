@@ -0,0 +1,250 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"runtime"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+	"github.com/ernado/ki/internal/install"
+)
+
+// nodeFlags are flags shared by commands that change node.
+type nodeFlags struct {
+	Log                    logFlags
+	Events                 string
+	Config                 string
+	DryRun                 bool
+	Version                string
+	CiliumVersion          string
+	CiliumCliVersion       string
+	CiliumCliSHA256        string
+	HelmVersion            string
+	HelmSHA256             string
+	ControlPlaneInternalIP string
+}
+
+func (f *nodeFlags) register(fs *flag.FlagSet) {
+	def := config.Default()
+	f.Log.register(fs)
+	fs.StringVar(&f.Events, "events", install.EventsPath, "path to JSON lines event stream, empty to disable")
+	fs.StringVar(&f.Config, "config", config.NodePath, "path to cluster spec, defaults are used if missing")
+	fs.BoolVar(&f.DryRun, "dry-run", false, "print commands and files instead of changing node")
+	fs.StringVar(&f.Version, "version", def.Kubernetes.Version, "kubernetes version")
+	fs.StringVar(&f.HelmVersion, "helm-version", def.Helm.Version, "helm version")
+	fs.StringVar(&f.HelmSHA256, "helm-sha256", def.Helm.SHA256[runtime.GOARCH], "helm sha256 for node architecture")
+	fs.StringVar(&f.CiliumVersion, "cilium-version", def.Cilium.Version, "cilium version")
+	fs.StringVar(&f.CiliumCliVersion, "cilium-cli-version", def.Cilium.CLI.Version, "cilium cli version")
+	fs.StringVar(&f.CiliumCliSHA256, "cilium-cli-sha256", def.Cilium.CLI.SHA256[runtime.GOARCH], "cilium cli sha256 for node architecture")
+	fs.StringVar(&f.ControlPlaneInternalIP, "control-plane-internal-ip", def.ControlPlane.InternalIP, "control plane internal ip")
+}
+
+// load sets up logging and loads cluster spec, overriding it with explicitly set flags.
+func (f *nodeFlags) load(fs *flag.FlagSet) (config.Config, error) {
+	if err := f.Log.setup(); err != nil {
+		return config.Config{}, err
+	}
+	cfg, err := config.Load(f.Config)
+	if err != nil {
+		return cfg, errors.Wrap(err, "load config")
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "version":
+			cfg.Kubernetes.Version = f.Version
+		case "helm-version":
+			cfg.Helm.Version = f.HelmVersion
+		case "helm-sha256":
+			// ki is built for node architecture.
+			cfg.Helm.SHA256 = config.Checksums{runtime.GOARCH: f.HelmSHA256}
+		case "cilium-version":
+			cfg.Cilium.Version = f.CiliumVersion
+		case "cilium-cli-version":
+			cfg.Cilium.CLI.Version = f.CiliumCliVersion
+		case "cilium-cli-sha256":
+			cfg.Cilium.CLI.SHA256 = config.Checksums{runtime.GOARCH: f.CiliumCliSHA256}
+		case "control-plane-internal-ip":
+			cfg.ControlPlane.InternalIP = f.ControlPlaneInternalIP
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		return cfg, errors.Wrap(err, "invalid config")
+	}
+	return cfg, nil
+}
+
+// installer creates Installer, returned function should be called
+// after it is no longer used.
+func (f *nodeFlags) installer() (*install.Installer, func(), error) {
+	if f.DryRun {
+		return install.New(install.NewDryRunner()), func() {}, nil
+	}
+	in := install.New(install.NewExecRunner())
+	if f.Events == "" {
+		return in, func() {}, nil
+	}
+	events, err := install.OpenEvents(f.Events)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "open events")
+	}
+	in.Events = events
+	return in, func() { _ = events.Close() }, nil
+}
+
+// stepFlags select install steps to run.
+type stepFlags struct {
+	FromStep      string
+	OnlyStep      string
+	SkipPreflight bool
+}
+
+func (f *stepFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.FromStep, "from-step", "", "run steps starting from named one, even if already completed")
+	fs.StringVar(&f.OnlyStep, "only-step", "", "run only named step, even if already completed")
+	fs.BoolVar(&f.SkipPreflight, "skip-preflight", false, "skip preflight checks")
+}
+
+func (f *stepFlags) options(cfg config.Config) install.RunStepsOptions {
+	opt := install.RunStepsOptions{
+		FromStep: f.FromStep,
+		OnlyStep: f.OnlyStep,
+		Timeout:  time.Duration(cfg.Steps.Timeout),
+		Timeouts: make(map[string]time.Duration, len(cfg.Steps.Timeouts)),
+	}
+	for name, t := range cfg.Steps.Timeouts {
+		opt.Timeouts[name] = time.Duration(t)
+	}
+	retry := retryPolicy(cfg.Steps.Retry)
+	opt.Retry = &retry
+	opt.Retries = make(map[string]install.RetryPolicy, len(cfg.Steps.Retries))
+	for name, r := range cfg.Steps.Retries {
+		opt.Retries[name] = retryPolicy(r.Merge(cfg.Steps.Retry))
+	}
+	return opt
+}
+
+func retryPolicy(r config.Retry) install.RetryPolicy {
+	return install.RetryPolicy{
+		InitialInterval: time.Duration(r.InitialInterval),
+		MaxInterval:     time.Duration(r.MaxInterval),
+		MaxElapsedTime:  time.Duration(r.MaxElapsedTime),
+	}
+}
+
+func runNode(ctx context.Context, args []string, name, short string, join bool) error {
+	var (
+		nf           nodeFlags
+		sf           stepFlags
+		controlPlane bool
+	)
+	fs := newFlagSet(name, short)
+	nf.register(fs)
+	sf.register(fs)
+	if join {
+		fs.BoolVar(&controlPlane, "control-plane", false, "join as control plane node")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := nf.load(fs)
+	if err != nil {
+		return err
+	}
+	in, done, err := nf.installer()
+	if err != nil {
+		return err
+	}
+	defer done()
+	return in.Run(ctx, install.RunOptions{
+		Config:        cfg,
+		Join:          join,
+		ControlPlane:  controlPlane,
+		Steps:         sf.options(cfg),
+		SkipPreflight: sf.SkipPreflight,
+	})
+}
+
+func runInit(ctx context.Context, args []string) error {
+	return runNode(ctx, args, "init", "Install node and initialize cluster as control plane", false)
+}
+
+func runJoin(ctx context.Context, args []string) error {
+	return runNode(ctx, args, "join", "Install node and join cluster as worker or control plane", true)
+}
+
+func runInstallService(ctx context.Context, args []string) error {
+	var (
+		nf           nodeFlags
+		join         bool
+		controlPlane bool
+	)
+	fs := newFlagSet("install-service", "Install ki as systemd service that runs init or join")
+	nf.register(fs)
+	fs.BoolVar(&join, "join", false, "join cluster instead of initializing it")
+	fs.BoolVar(&controlPlane, "control-plane", false, "join as control plane node, used with --join")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if controlPlane && !join {
+		return errors.New("--control-plane requires --join")
+	}
+	if _, err := nf.load(fs); err != nil {
+		return err
+	}
+	in, done, err := nf.installer()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := in.Service(ctx, install.ServiceOptions{Join: join, ControlPlane: controlPlane}); err != nil {
+		return errors.Wrap(err, "service")
+	}
+	return nil
+}
+
+// runLegacy runs ki with flags from before subcommands were introduced:
+//
+//	ki --install [--join]  # install-service
+//	ki --join              # join
+//	ki                     # init
+func runLegacy(ctx context.Context, args []string) error {
+	var (
+		nf      nodeFlags
+		sf      stepFlags
+		join    bool
+		service bool
+	)
+	fs := newFlagSet("", "Legacy flags, use subcommands instead")
+	nf.register(fs)
+	sf.register(fs)
+	fs.BoolVar(&join, "join", false, "join cluster")
+	fs.BoolVar(&service, "install", false, "install")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := nf.load(fs)
+	if err != nil {
+		return err
+	}
+	in, done, err := nf.installer()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if service {
+		// Only installing as service, not running.
+		if err := in.Service(ctx, install.ServiceOptions{Join: join}); err != nil {
+			return errors.Wrap(err, "service")
+		}
+		return nil
+	}
+	return in.Run(ctx, install.RunOptions{
+		Config:        cfg,
+		Join:          join,
+		Steps:         sf.options(cfg),
+		SkipPreflight: sf.SkipPreflight,
+	})
+}
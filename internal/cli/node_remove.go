@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+	"github.com/ernado/ki/internal/install"
+)
+
+func runNodeRemove(ctx context.Context, args []string) error {
+	var (
+		lf         logFlags
+		cfgPath    string
+		dryRun     bool
+		kubeconfig string
+		reset      bool
+		sshUser    string
+		keepServer bool
+		force      bool
+	)
+	fs := newFlagSet("node remove", "Drain and delete node from cluster and Hetzner Cloud")
+	lf.register(fs)
+	fs.StringVar(&cfgPath, "config", config.NodePath, "path to cluster spec, defaults are used if missing")
+	fs.BoolVar(&dryRun, "dry-run", false, "print commands instead of changing cluster")
+	fs.StringVar(&kubeconfig, "kubeconfig", "/etc/kubernetes/admin.conf", "kubeconfig with admin access")
+	fs.BoolVar(&reset, "reset", false, "run kubeadm reset on node over SSH, e.g. with forwarded agent")
+	fs.StringVar(&sshUser, "ssh-user", "cluster", "SSH user with passwordless sudo on node, used with --reset")
+	fs.BoolVar(&keepServer, "keep-server", false, "do not delete Hetzner server")
+	fs.BoolVar(&force, "force", false, "delete server of node missing in cluster even if its role is unknown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: ki node remove [flags] <name>")
+	}
+	if err := lf.setup(); err != nil {
+		return err
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+	nf := nodeFlags{DryRun: dryRun}
+	in, done, err := nf.installer()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := in.RemoveNode(ctx, install.RemoveNodeOptions{
+		Name:       fs.Arg(0),
+		Kubeconfig: kubeconfig,
+		Reset:      reset,
+		SSHUser:    sshUser,
+		TokenPath:  cfg.Hetzner.TokenPath,
+		KeepServer: keepServer,
+		Force:      force,
+	}); err != nil {
+		return errors.Wrap(err, "remove node")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+	"github.com/ernado/ki/internal/install"
+)
+
+func runPreflight(ctx context.Context, args []string) error {
+	var (
+		asJSON  bool
+		join    bool
+		cfgPath string
+	)
+	fs := newFlagSet("preflight", "Check that node is suitable for install")
+	fs.BoolVar(&asJSON, "json", false, "print report as JSON")
+	fs.BoolVar(&join, "join", false, "check node for joining as worker")
+	fs.StringVar(&cfgPath, "config", config.NodePath, "path to cluster spec, defaults are used if missing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+	report := install.New(install.NewExecRunner()).Preflight(ctx, install.PreflightOptions{
+		Config: cfg,
+		Join:   join,
+	})
+	if asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(report); err != nil {
+			return errors.Wrap(err, "encode")
+		}
+	} else {
+		report.Print(os.Stdout)
+	}
+	if report.Failed() {
+		return errors.New("preflight checks failed")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/install"
+)
+
+func runReset(ctx context.Context, args []string) error {
+	var (
+		lf           logFlags
+		dryRun       bool
+		keepPackages bool
+	)
+	fs := newFlagSet("reset", "Undo changes made by ki to node")
+	lf.register(fs)
+	fs.BoolVar(&dryRun, "dry-run", false, "print commands and files instead of changing node")
+	fs.BoolVar(&keepPackages, "keep-packages", false, "keep installed packages and binaries, only unhold them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := lf.setup(); err != nil {
+		return err
+	}
+	// Not using events, because their directory is removed by reset.
+	nf := nodeFlags{DryRun: dryRun}
+	in, done, err := nf.installer()
+	if err != nil {
+		return err
+	}
+	defer done()
+	summary, err := in.Reset(ctx, install.ResetOptions{
+		KeepPackages: keepPackages,
+	})
+	fmt.Println("> Reverted:")
+	if len(summary) == 0 {
+		fmt.Println("  nothing")
+	}
+	for _, s := range summary {
+		fmt.Println("  -", s)
+	}
+	if err != nil {
+		return errors.Wrap(err, "reset")
+	}
+	return nil
+}
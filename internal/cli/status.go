@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/install"
+)
+
+func runStatus(ctx context.Context, args []string) error {
+	var asJSON bool
+	fs := newFlagSet("status", "Show node status and install progress")
+	fs.BoolVar(&asJSON, "json", false, "print status as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := install.New(install.NewExecRunner()).Status(ctx)
+	if err != nil {
+		return errors.Wrap(err, "status")
+	}
+	if asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(s)
+	}
+	fmt.Println("Role:   ", s.Role)
+	fmt.Println("Kubelet:", s.Kubelet)
+	if len(s.State.Completed) == 0 {
+		fmt.Println("Steps:   none completed")
+	} else {
+		names := make([]string, 0, len(s.State.Completed))
+		for name := range s.State.Completed {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return s.State.Completed[names[i]].Before(s.State.Completed[names[j]])
+		})
+		fmt.Println("Steps:")
+		for _, name := range names {
+			fmt.Printf("  %-24s completed %s\n", name, s.State.Completed[name].Format(time.RFC3339))
+		}
+	}
+	if s.State.Failed != "" {
+		fmt.Printf("Failed:  %s: %s\n", s.State.Failed, s.State.Error)
+	}
+	return nil
+}
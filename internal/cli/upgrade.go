@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/install"
+)
+
+func runUpgrade(ctx context.Context, args []string) error {
+	var (
+		lf         logFlags
+		dryRun     bool
+		to         string
+		nodeName   string
+		kubeconfig string
+		skipDrain  bool
+	)
+	fs := newFlagSet("upgrade", "Upgrade kubernetes on node")
+	lf.register(fs)
+	fs.BoolVar(&dryRun, "dry-run", false, "print commands and files instead of changing node")
+	fs.StringVar(&to, "to", "", "target minor version, like v1.32, one minor version above current")
+	fs.StringVar(&nodeName, "node", "", "node name, hostname by default")
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig that can drain nodes, admin.conf on control plane by default, required on workers unless --skip-drain")
+	fs.BoolVar(&skipDrain, "skip-drain", false, "do not drain and uncordon node, e.g. if drained by other means")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := lf.setup(); err != nil {
+		return err
+	}
+	if to == "" {
+		return errors.New("--to is required")
+	}
+	if nodeName == "" {
+		name, err := install.NodeName()
+		if err != nil {
+			return errors.Wrap(err, "node name")
+		}
+		nodeName = name
+	}
+	nf := nodeFlags{DryRun: dryRun}
+	in, done, err := nf.installer()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := in.Upgrade(ctx, install.UpgradeOptions{
+		To:         to,
+		NodeName:   nodeName,
+		Kubeconfig: kubeconfig,
+		SkipDrain:  skipDrain,
+	}); err != nil {
+		return errors.Wrap(err, "upgrade")
+	}
+	return nil
+}
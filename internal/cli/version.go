@@ -0,0 +1,17 @@
+package cli
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func runVersion(b Build, args []string) error {
+	fs := newFlagSet("version", "Print ki version")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fmt.Printf("ki %s (commit %s, built %s, %s %s/%s)\n",
+		b.Version, b.Commit, b.Date, runtime.Version(), runtime.GOOS, runtime.GOARCH,
+	)
+	return nil
+}
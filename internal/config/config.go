@@ -0,0 +1,562 @@
+// Package config implements declarative cluster spec (ki.yaml).
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	APIVersion = "ki/v1alpha1"
+	Kind       = "Cluster"
+
+	// NodePath is path to cluster spec on node.
+	NodePath = "/etc/ki/ki.yaml"
+	// LocalPath is path to cluster spec in terraform directory.
+	LocalPath = "ki.yaml"
+
+	// JoinSecretPath is path to pre-shared join secret on nodes.
+	JoinSecretPath = "/etc/ki/join-secret"
+	// ControlPlaneJoinSecretPath is path to pre-shared join secret on control
+	// plane nodes, required to get certificate key.
+	ControlPlaneJoinSecretPath = "/etc/ki/control-plane-join-secret"
+	// JoinCertPath is path to join server certificate on first control plane node.
+	JoinCertPath = "/etc/ki/join.crt"
+	// JoinKeyPath is path to join server key on first control plane node.
+	JoinKeyPath = "/etc/ki/join.key"
+
+	// BootstrapTokenPath is path to pre-generated bootstrap token on nodes.
+	BootstrapTokenPath = "/etc/ki/bootstrap-token"
+	// CACertPath is path to pre-generated cluster CA certificate on first control plane node.
+	CACertPath = "/etc/ki/ca.crt"
+	// CAKeyPath is path to pre-generated cluster CA key on first control plane node.
+	CAKeyPath = "/etc/ki/ca.key"
+)
+
+// Config is cluster spec.
+type Config struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+
+	Kubernetes   Kubernetes   `yaml:"kubernetes"`
+	Hetzner      Hetzner      `yaml:"hetzner"`
+	ControlPlane ControlPlane `yaml:"controlPlane"`
+	Workers      Workers      `yaml:"workers"`
+	Join         Join         `yaml:"join"`
+	Helm         Binary       `yaml:"helm"`
+	Cilium       Cilium       `yaml:"cilium"`
+	Addons       Addons       `yaml:"addons"`
+	Node         Node         `yaml:"node"`
+	Kubeadm      Kubeadm      `yaml:"kubeadm"`
+	Steps        Steps        `yaml:"steps"`
+	Backup       Backup       `yaml:"backup"`
+	Certs        Certs        `yaml:"certs"`
+}
+
+type Kubernetes struct {
+	ClusterName string   `yaml:"clusterName"`
+	Version     string   `yaml:"version"` // v1.31
+	PodCIDR     string   `yaml:"podCIDR"`
+	ServiceCIDR string   `yaml:"serviceCIDR"`
+	SkipPhases  []string `yaml:"skipPhases"`
+}
+
+type Hetzner struct {
+	// Network is name of private network.
+	Network string `yaml:"network"`
+	// TokenPath is path to file with API token on control plane node.
+	TokenPath    string `yaml:"tokenPath"`
+	Location     string `yaml:"location"`
+	SSHKeyName   string `yaml:"sshKeyName"`
+	LoadBalancer string `yaml:"loadBalancer"`
+	// Image is server image, ubuntu-22.04, ubuntu-24.04, debian-12 and debian-13 are supported.
+	Image string `yaml:"image"`
+	// MetadataURL is base URL of metadata service, that is queried for
+	// node IP, provider ID and topology labels. Disabled if empty.
+	MetadataURL string `yaml:"metadataURL"`
+}
+
+type ControlPlane struct {
+	// InternalIP is private IP of first control plane node, that initializes cluster.
+	InternalIP string `yaml:"internalIP"`
+	ServerType string `yaml:"serverType"`
+	// Count of control plane nodes, more than one requires Endpoint.
+	Count int `yaml:"count"`
+	// Endpoint is stable address of API server, e.g. private IP of
+	// load balancer in front of control plane nodes. Private IP of first
+	// control plane node is used if empty.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// PublicSAN adds public IP of first control plane node to API server
+	// certificate, for kubectl access from outside of private network.
+	PublicSAN bool `yaml:"publicSAN,omitempty"`
+}
+
+// Join configures join server on first control plane node, that hands out
+// join parameters to nodes presenting pre-shared secret.
+type Join struct {
+	// Port of join server on private IP of first control plane node.
+	Port int `yaml:"port"`
+	// Fingerprint pins join server certificate, it is SHA-256 hash of
+	// certificate public key like "sha256:<hex>". Set by ki-prepare-tf.
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+	// CACertHash is SHA-256 hash of pre-generated cluster CA public key,
+	// like "sha256:<hex>". If set, workers join with pre-generated
+	// bootstrap token instead of asking join server. Set by ki-prepare-tf.
+	CACertHash string `yaml:"caCertHash,omitempty"`
+	// TokenTTL is lifetime of pre-generated bootstrap token, zero for
+	// token that never expires. Workers created later fall back to join
+	// server if token has expired.
+	TokenTTL Duration `yaml:"tokenTTL"`
+}
+
+// JoinServerAddr returns address of join server on first control plane node.
+func (c Config) JoinServerAddr() string {
+	return net.JoinHostPort(c.ControlPlane.InternalIP, strconv.Itoa(c.Join.Port))
+}
+
+type Workers struct {
+	ServerType string `yaml:"serverType"`
+	Count      int    `yaml:"count"`
+}
+
+// Backup configures etcd snapshots, enabled if S3 bucket is set.
+type Backup struct {
+	// Schedule is systemd OnCalendar expression, like daily or *-*-* 00/6:00:00.
+	Schedule string `yaml:"schedule"`
+	// Keep is number of latest snapshots kept in bucket.
+	Keep int `yaml:"keep"`
+	S3   S3  `yaml:"s3"`
+}
+
+// S3 is S3-compatible object storage, like Hetzner Object Storage.
+type S3 struct {
+	// Endpoint is base URL, like https://fsn1.your-objectstorage.com.
+	Endpoint string `yaml:"endpoint"`
+	Region   string `yaml:"region"`
+	Bucket   string `yaml:"bucket"`
+	// Prefix of object keys.
+	Prefix string `yaml:"prefix"`
+	// CredentialsPath is path to file with AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY lines on control plane node.
+	CredentialsPath string `yaml:"credentialsPath"`
+}
+
+// Certs configures certificate expiry checks.
+type Certs struct {
+	// ExpiryWindow is time before expiry when certificate is reported as
+	// expiring and should be renewed.
+	ExpiryWindow Duration `yaml:"expiryWindow"`
+}
+
+// Architectures are supported node architectures, in Debian naming.
+var Architectures = []string{"amd64", "arm64"}
+
+// ServerArch returns architecture of Hetzner server type,
+// Ampere servers (cax11, cax21, ...) are arm64.
+func ServerArch(serverType string) string {
+	if strings.HasPrefix(serverType, "cax") {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// Binary is release archive of binary tool.
+type Binary struct {
+	Version string `yaml:"version"`
+	// SHA256 maps architecture to archive checksum, required for
+	// architecture of every node.
+	SHA256 Checksums `yaml:"sha256"`
+}
+
+// Checksums maps architecture to SHA256 hex digest.
+//
+// Single digest is accepted for compatibility and is used for amd64.
+type Checksums map[string]string
+
+func (c *Checksums) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var s string
+		if err := n.Decode(&s); err != nil {
+			return err
+		}
+		*c = Checksums{"amd64": s}
+		return nil
+	}
+	// Replacing defaults, because they are for default version.
+	var m map[string]string
+	if err := n.Decode(&m); err != nil {
+		return err
+	}
+	*c = m
+	return nil
+}
+
+type Cilium struct {
+	// Version of helm chart.
+	Version string `yaml:"version"`
+	CLI     Binary `yaml:"cli"`
+}
+
+type Addons struct {
+	// DemoIngress installs demo http server with ingress.
+	DemoIngress       bool `yaml:"demoIngress"`
+	ServiceMonitorCRD bool `yaml:"serviceMonitorCRD"`
+	HetznerCSI        bool `yaml:"hetznerCSI"`
+	// HetznerCCM installs Hetzner Cloud Controller Manager with private
+	// network support and starts kubelet with external cloud provider.
+	HetznerCCM bool `yaml:"hetznerCCM"`
+}
+
+type Node struct {
+	KernelModules []string          `yaml:"kernelModules"`
+	Sysctl        map[string]string `yaml:"sysctl"`
+}
+
+// Kubeadm overrides rendered kubeadm configuration.
+//
+// Documents are merged over ones generated by ki, so any field of kubeadm
+// v1beta4 (e.g. apiServer.extraArgs, etcd, featureGates) and kubelet v1beta1
+// configuration can be set.
+type Kubeadm struct {
+	InitConfiguration    map[string]any `yaml:"initConfiguration,omitempty"`
+	ClusterConfiguration map[string]any `yaml:"clusterConfiguration,omitempty"`
+	KubeletConfiguration map[string]any `yaml:"kubeletConfiguration,omitempty"`
+	JoinConfiguration    map[string]any `yaml:"joinConfiguration,omitempty"`
+}
+
+// Steps configures install steps.
+type Steps struct {
+	// Timeout limits duration of each step, zero means no limit.
+	Timeout Duration `yaml:"timeout"`
+	// Timeouts overrides Timeout for named steps, e.g. kubeadm-init.
+	Timeouts map[string]Duration `yaml:"timeouts,omitempty"`
+	// Retry is policy for network-dependent operations, like downloads,
+	// apt-get update and helm.
+	Retry Retry `yaml:"retry"`
+	// Retries overrides Retry fields for named steps.
+	Retries map[string]Retry `yaml:"retries,omitempty"`
+}
+
+// Retry is exponential backoff policy.
+type Retry struct {
+	InitialInterval Duration `yaml:"initialInterval"`
+	MaxInterval     Duration `yaml:"maxInterval"`
+	// MaxElapsedTime limits total time of all attempts, zero disables retries.
+	MaxElapsedTime Duration `yaml:"maxElapsedTime"`
+}
+
+// Merge returns r with zero fields set from base.
+func (r Retry) Merge(base Retry) Retry {
+	if r.InitialInterval == 0 {
+		r.InitialInterval = base.InitialInterval
+	}
+	if r.MaxInterval == 0 {
+		r.MaxInterval = base.MaxInterval
+	}
+	if r.MaxElapsedTime == 0 {
+		r.MaxElapsedTime = base.MaxElapsedTime
+	}
+	return r
+}
+
+// Duration is time.Duration encoded as string like "20m" in YAML.
+type Duration time.Duration
+
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Wrap(err, "parse duration")
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Default returns spec with default values.
+func Default() Config {
+	return Config{
+		APIVersion: APIVersion,
+		Kind:       Kind,
+		Kubernetes: Kubernetes{
+			ClusterName: "kubernetes",
+			Version:     "v1.31",
+			PodCIDR:     "10.244.0.0/16",
+			ServiceCIDR: "10.96.0.0/16",
+			SkipPhases:  []string{"addon/kube-proxy"},
+		},
+		Hetzner: Hetzner{
+			Network:      "kubernetes-cluster",
+			TokenPath:    "/root/.hcloud",
+			Location:     "hel1",
+			SSHKeyName:   "ki",
+			LoadBalancer: "kubernetes-load-balancer",
+			Image:        "ubuntu-24.04",
+			MetadataURL:  "http://169.254.169.254/hetzner/v1/metadata",
+		},
+		ControlPlane: ControlPlane{
+			InternalIP: "10.0.1.1",
+			ServerType: "cpx11",
+			Count:      1,
+		},
+		Workers: Workers{
+			ServerType: "cpx11",
+			Count:      1,
+		},
+		Join: Join{
+			Port:     9444,
+			TokenTTL: Duration(24 * time.Hour),
+		},
+		Helm: Binary{
+			Version: "v3.17.0",
+			SHA256: Checksums{
+				"amd64": "fb5d12662fde6eeff36ac4ccacbf3abed96b0ee2de07afdde4edb14e613aee24",
+				"arm64": "c4d4be8e80082b7eaa411e3e231d62cf05d01cddfef59b0d01006a7901e11ee4",
+			},
+		},
+		Cilium: Cilium{
+			Version: "1.17.0",
+			CLI: Binary{
+				Version: "v0.16.24",
+				SHA256: Checksums{
+					"amd64": "019c9c765222b3db5786f7b3a0bff2cd62944a8ce32681acfb47808330f405a7",
+				},
+			},
+		},
+		Addons: Addons{
+			DemoIngress:       true,
+			ServiceMonitorCRD: true,
+			HetznerCSI:        true,
+			HetznerCCM:        true,
+		},
+		Node: Node{
+			KernelModules: []string{"overlay", "br_netfilter"},
+			Sysctl: map[string]string{
+				"net.bridge.bridge-nf-call-ip6tables": "1",
+				"net.bridge.bridge-nf-call-iptables":  "1",
+				"net.ipv4.ip_forward":                 "1",
+			},
+		},
+		Steps: Steps{
+			Timeout: Duration(20 * time.Minute),
+			Retry: Retry{
+				InitialInterval: Duration(2 * time.Second),
+				MaxInterval:     Duration(30 * time.Second),
+				MaxElapsedTime:  Duration(5 * time.Minute),
+			},
+		},
+		Backup: Backup{
+			Schedule: "daily",
+			Keep:     7,
+			S3: S3{
+				Prefix:          "etcd/",
+				CredentialsPath: "/etc/ki/backup-credentials",
+			},
+		},
+		Certs: Certs{
+			ExpiryWindow: Duration(30 * 24 * time.Hour),
+		},
+	}
+}
+
+// Parse decodes spec, using default values for missing fields.
+func Parse(r io.Reader) (Config, error) {
+	cfg := Default()
+	// Default sysctl values are replaced, not merged.
+	cfg.Node.Sysctl = nil
+	d := yaml.NewDecoder(r)
+	d.KnownFields(true)
+	if err := d.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, errors.Wrap(err, "decode")
+	}
+	if cfg.Node.Sysctl == nil {
+		cfg.Node.Sysctl = Default().Node.Sysctl
+	}
+	return cfg, nil
+}
+
+// Load reads spec from file.
+//
+// If file does not exist, default spec is returned.
+func Load(name string) (Config, error) {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return Default(), nil
+	}
+	if err != nil {
+		return Config{}, errors.Wrap(err, "read")
+	}
+	cfg, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return cfg, errors.Wrapf(err, "parse %s", name)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, errors.Wrapf(err, "invalid %s", name)
+	}
+	return cfg, nil
+}
+
+// Marshal encodes spec to YAML.
+func (c Config) Marshal() ([]byte, error) {
+	var b bytes.Buffer
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	if err := e.Encode(c); err != nil {
+		return nil, errors.Wrap(err, "encode")
+	}
+	return b.Bytes(), nil
+}
+
+var (
+	reVersion     = regexp.MustCompile(`^v1\.\d+$`)
+	reFingerprint = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+	// reHostname matches DNS name, labels are up to 63 characters.
+	reHostname = regexp.MustCompile(`^(?i)[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?(\.[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?)*$`)
+)
+
+// MajorMinor returns major and minor of version, like v1.31 for v1.31.5.
+func MajorMinor(v string) string {
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return v
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// MinorVersion returns minor of kubernetes version like v1.31.
+func MinorVersion(v string) (int, bool) {
+	if !reVersion.MatchString(v) {
+		return 0, false
+	}
+	minor, err := strconv.Atoi(strings.TrimPrefix(v, "v1."))
+	if err != nil {
+		return 0, false
+	}
+	return minor, true
+}
+
+// Validate checks spec, returning all found problems.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	isCIDR := func(s string) bool {
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	}
+	isHost := func(s string) bool {
+		return net.ParseIP(s) != nil || (len(s) <= 253 && reHostname.MatchString(s))
+	}
+	isSHA256 := func(s string) bool {
+		b, err := hex.DecodeString(s)
+		return err == nil && len(b) == 32
+	}
+
+	check(c.APIVersion == APIVersion, "apiVersion: got %q, expected %q", c.APIVersion, APIVersion)
+	check(c.Kind == Kind, "kind: got %q, expected %q", c.Kind, Kind)
+	check(c.Kubernetes.ClusterName != "", "kubernetes.clusterName: should be set")
+	check(reVersion.MatchString(c.Kubernetes.Version), "kubernetes.version: %q is not a minor version like v1.31", c.Kubernetes.Version)
+	if minor, ok := MinorVersion(c.Kubernetes.Version); ok {
+		// Rendered kubeadm configuration is v1beta4.
+		check(minor >= 31, "kubernetes.version: %q is not supported, at least v1.31 is required", c.Kubernetes.Version)
+	}
+	check(isCIDR(c.Kubernetes.PodCIDR), "kubernetes.podCIDR: %q is not a CIDR", c.Kubernetes.PodCIDR)
+	check(isCIDR(c.Kubernetes.ServiceCIDR), "kubernetes.serviceCIDR: %q is not a CIDR", c.Kubernetes.ServiceCIDR)
+	check(c.Hetzner.Network != "", "hetzner.network: should be set")
+	check(c.Hetzner.TokenPath != "", "hetzner.tokenPath: should be set")
+	check(c.Hetzner.Image != "", "hetzner.image: should be set")
+	check(net.ParseIP(c.ControlPlane.InternalIP) != nil, "controlPlane.internalIP: %q is not an IP", c.ControlPlane.InternalIP)
+	check(c.ControlPlane.Count >= 1, "controlPlane.count: should be at least 1")
+	check(c.ControlPlane.Count <= 1 || c.ControlPlane.Endpoint != "", "controlPlane.endpoint: should be set for %d control plane nodes", c.ControlPlane.Count)
+	if e := c.ControlPlane.Endpoint; e != "" {
+		// Port 6443 is appended to endpoint.
+		host, port, err := net.SplitHostPort(e)
+		_, portErr := strconv.Atoi(port)
+		switch {
+		case isHost(e):
+		case err == nil && portErr == nil && isHost(host):
+			check(false, "controlPlane.endpoint: %q should not contain port, 6443 is used", e)
+		default:
+			check(false, "controlPlane.endpoint: %q is not an IP or DNS name", e)
+		}
+	}
+	check(c.Workers.Count >= 0, "workers.count: should not be negative")
+	check(c.Join.Port > 0 && c.Join.Port < 65536, "join.port: %d is not a port", c.Join.Port)
+	check(c.Join.Fingerprint == "" || reFingerprint.MatchString(c.Join.Fingerprint), "join.fingerprint: %q is not like sha256:<hex>", c.Join.Fingerprint)
+	check(c.Join.CACertHash == "" || reFingerprint.MatchString(c.Join.CACertHash), "join.caCertHash: %q is not like sha256:<hex>", c.Join.CACertHash)
+	check(c.Join.TokenTTL >= 0, "join.tokenTTL: should not be negative")
+	check(c.Certs.ExpiryWindow >= 0, "certs.expiryWindow: should not be negative")
+	check(c.Helm.Version != "", "helm.version: should be set")
+	// Archives are downloaded on nodes only with pinned checksum.
+	nodeArchs := []string{ServerArch(c.ControlPlane.ServerType)}
+	if arch := ServerArch(c.Workers.ServerType); c.Workers.Count > 0 && !slices.Contains(nodeArchs, arch) {
+		nodeArchs = append(nodeArchs, arch)
+	}
+	checkChecksums := func(path string, sums Checksums) {
+		for arch, sum := range sums {
+			check(slices.Contains(Architectures, arch), "%s: unsupported architecture %q", path, arch)
+			check(isSHA256(sum), "%s.%s: %q is not a SHA256 hex digest", path, arch, sum)
+		}
+		for _, arch := range nodeArchs {
+			check(sums[arch] != "", "%s.%s: checksum should be pinned for %s nodes", path, arch, arch)
+		}
+	}
+	checkChecksums("helm.sha256", c.Helm.SHA256)
+	check(c.Cilium.Version != "", "cilium.version: should be set")
+	check(c.Cilium.CLI.Version != "", "cilium.cli.version: should be set")
+	checkChecksums("cilium.cli.sha256", c.Cilium.CLI.SHA256)
+	check(c.Steps.Timeout >= 0, "steps.timeout: should not be negative")
+	for name, t := range c.Steps.Timeouts {
+		check(t >= 0, "steps.timeouts.%s: should not be negative", name)
+	}
+	checkRetry := func(path string, r Retry) {
+		check(r.InitialInterval >= 0 && r.MaxInterval >= 0 && r.MaxElapsedTime >= 0, "%s: intervals should not be negative", path)
+		check(r.MaxInterval == 0 || r.InitialInterval <= r.MaxInterval, "%s: initialInterval should not exceed maxInterval", path)
+	}
+	checkRetry("steps.retry", c.Steps.Retry)
+	for name, r := range c.Steps.Retries {
+		checkRetry("steps.retries."+name, r)
+	}
+	if c.Hetzner.MetadataURL != "" {
+		u, err := url.Parse(c.Hetzner.MetadataURL)
+		check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "hetzner.metadataURL: %q is not a URL", c.Hetzner.MetadataURL)
+	}
+	if c.Backup.S3.Bucket != "" {
+		u, err := url.Parse(c.Backup.S3.Endpoint)
+		check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "backup.s3.endpoint: %q is not a URL", c.Backup.S3.Endpoint)
+		check(c.Backup.S3.Region != "", "backup.s3.region: should be set")
+		check(c.Backup.S3.CredentialsPath != "", "backup.s3.credentialsPath: should be set")
+		check(c.Backup.Schedule != "", "backup.schedule: should be set")
+		check(c.Backup.Keep >= 1, "backup.keep: should be at least 1")
+	}
+	for _, m := range c.Node.KernelModules {
+		check(m != "" && !strings.ContainsAny(m, " \t/"), "node.kernelModules: %q is not a module name", m)
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		Name  string
+		Input string
+		Error string
+		// Expected is applied to default spec to get expected result.
+		Expected func(c *Config)
+	}{
+		{Name: "Empty", Expected: func(c *Config) {}},
+		{
+			Name: "Override",
+			Input: `apiVersion: ki/v1alpha1
+kind: Cluster
+kubernetes:
+  version: v1.32
+controlPlane:
+  count: 3
+  endpoint: 10.0.1.100
+steps:
+  timeouts:
+    kubeadm-init: 30m
+`,
+			Expected: func(c *Config) {
+				c.Kubernetes.Version = "v1.32"
+				c.ControlPlane.Count = 3
+				c.ControlPlane.Endpoint = "10.0.1.100"
+				c.Steps.Timeouts = map[string]Duration{"kubeadm-init": Duration(30 * time.Minute)}
+			},
+		},
+		{
+			Name: "SysctlReplaced",
+			Input: `node:
+  sysctl:
+    vm.max_map_count: "262144"
+`,
+			Expected: func(c *Config) {
+				c.Node.Sysctl = map[string]string{"vm.max_map_count": "262144"}
+			},
+		},
+		{
+			Name: "ChecksumShorthand",
+			Input: `helm:
+  version: v3.17.1
+  sha256: 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+`,
+			Expected: func(c *Config) {
+				c.Helm = Binary{
+					Version: "v3.17.1",
+					SHA256:  Checksums{"amd64": "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+				}
+			},
+		},
+		{Name: "UnknownField", Input: "kubernetes:\n  versoin: v1.32\n", Error: "versoin"},
+		{Name: "BadDuration", Input: "steps:\n  timeout: 20\n", Error: "parse duration"},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			cfg, err := Parse(strings.NewReader(tt.Input))
+			if tt.Error != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.Error) {
+					t.Fatalf("got error %v, expected %q", err, tt.Error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := Default()
+			tt.Expected(&expected)
+			if !reflect.DeepEqual(cfg, expected) {
+				t.Errorf("got %+v, expected %+v", cfg, expected)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const sum = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	for _, tt := range []struct {
+		Name   string
+		Update func(c *Config)
+		Error  string
+	}{
+		{Name: "Default", Update: func(c *Config) {}},
+		{
+			Name: "HA",
+			Update: func(c *Config) {
+				c.ControlPlane.Count = 3
+				c.ControlPlane.Endpoint = "10.0.1.100"
+			},
+		},
+		{
+			Name: "EndpointDNSName",
+			Update: func(c *Config) {
+				c.ControlPlane.Count = 3
+				c.ControlPlane.Endpoint = "api.k8s.example.com"
+			},
+		},
+		{
+			Name:   "EndpointRequired",
+			Update: func(c *Config) { c.ControlPlane.Count = 3 },
+			Error:  "controlPlane.endpoint: should be set for 3 control plane nodes",
+		},
+		{
+			Name:   "EndpointPort",
+			Update: func(c *Config) { c.ControlPlane.Endpoint = "lb:6443" },
+			Error:  `controlPlane.endpoint: "lb:6443" should not contain port`,
+		},
+		{
+			Name:   "EndpointIPv6Port",
+			Update: func(c *Config) { c.ControlPlane.Endpoint = "[fd00::1]:6443" },
+			Error:  "should not contain port",
+		},
+		{
+			Name:   "EndpointURL",
+			Update: func(c *Config) { c.ControlPlane.Endpoint = "https://lb" },
+			Error:  `controlPlane.endpoint: "https://lb" is not an IP or DNS name`,
+		},
+		{
+			Name:   "EndpointSpace",
+			Update: func(c *Config) { c.ControlPlane.Endpoint = "my lb" },
+			Error:  "is not an IP or DNS name",
+		},
+		{
+			Name: "Arm64",
+			Update: func(c *Config) {
+				c.ControlPlane.ServerType = "cax11"
+				c.Workers.ServerType = "cax21"
+				c.Cilium.CLI.SHA256["arm64"] = sum
+			},
+		},
+		{
+			Name:   "Arm64Unpinned",
+			Update: func(c *Config) { c.Workers.ServerType = "cax21" },
+			Error:  "cilium.cli.sha256.arm64: checksum should be pinned for arm64 nodes",
+		},
+		{
+			Name:   "Version",
+			Update: func(c *Config) { c.Kubernetes.Version = "v1.31.5" },
+			Error:  `kubernetes.version: "v1.31.5" is not a minor version like v1.31`,
+		},
+		{
+			Name:   "OldVersion",
+			Update: func(c *Config) { c.Kubernetes.Version = "v1.30" },
+			Error:  "at least v1.31 is required",
+		},
+		{
+			Name:   "Backup",
+			Update: func(c *Config) { c.Backup.S3.Bucket = "etcd" },
+			Error:  `backup.s3.endpoint: "" is not a URL; backup.s3.region: should be set`,
+		},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			cfg := Default()
+			tt.Update(&cfg)
+			err := cfg.Validate()
+			switch {
+			case tt.Error == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.Error != "" && (err == nil || !strings.Contains(err.Error(), tt.Error)):
+				t.Errorf("got error %v, expected %q", err, tt.Error)
+			}
+		})
+	}
+}
+
+func TestDefaultChecksums(t *testing.T) {
+	cfg := Default()
+	for _, b := range []Binary{cfg.Helm, cfg.Cilium.CLI} {
+		if b.SHA256["amd64"] == "" {
+			t.Errorf("%s: amd64 checksum is not pinned", b.Version)
+		}
+	}
+	if cfg.Helm.SHA256["arm64"] == "" {
+		t.Error("helm arm64 checksum is not pinned")
+	}
+}
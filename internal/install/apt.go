@@ -2,103 +2,136 @@ package install
 
 import (
 	"bytes"
-	"fmt"
-	"io"
-	"net/http"
+	"context"
+	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/go-faster/errors"
 )
 
-func APTUpdate() error {
-	fmt.Println("> apt-get update")
-	cmd := exec.Command("apt-get", "update")
-	cmd.Env = appendDebianFrontend(os.Environ())
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+func (i *Installer) APTUpdate(ctx context.Context) error {
+	slog.Info("apt-get update")
+	if err := retry(ctx, "apt-get update", func() error {
+		return i.Runner.Run(ctx, Cmd{
+			Name: "apt-get",
+			Args: []string{"update"},
+			Env:  debianFrontend(),
+		})
+	}); err != nil {
 		return errors.Wrap(err, "apt update")
 	}
 	return nil
 }
 
-func appendDebianFrontend(vars []string) []string {
-	return appendEnv(vars, "DEBIAN_FRONTEND", "noninteractive")
+func debianFrontend() []string {
+	return appendEnv(nil, "DEBIAN_FRONTEND", "noninteractive")
 }
 
-func APTUpgrade() error {
-	fmt.Println("> apt-get upgrade")
-	cmd := exec.Command("apt-get", "upgrade", "-y")
-	cmd.Env = appendDebianFrontend(os.Environ())
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+func (i *Installer) APTUpgrade(ctx context.Context) error {
+	slog.Info("apt-get upgrade")
+	if err := i.Runner.Run(ctx, Cmd{
+		Name: "apt-get",
+		Args: []string{"upgrade", "-y"},
+		Env:  debianFrontend(),
+	}); err != nil {
 		return errors.Wrap(err, "apt upgrade")
 	}
 	return nil
 }
 
-func APTInstall(packages ...string) error {
-	fmt.Println("> apt-get install", packages)
-	cmd := exec.Command("apt-get", append([]string{"install", "-y"}, packages...)...)
-	cmd.Env = appendDebianFrontend(os.Environ())
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+func (i *Installer) APTInstall(ctx context.Context, packages ...string) error {
+	slog.Info("apt-get install", "packages", packages)
+	if err := i.Runner.Run(ctx, Cmd{
+		Name: "apt-get",
+		Args: append([]string{"install", "-y"}, packages...),
+		Env:  debianFrontend(),
+	}); err != nil {
 		return errors.Wrap(err, "apt install")
 	}
 	return nil
 }
 
-func APTHold(packages ...string) error {
-	fmt.Println("> apt-mark hold", packages)
-	cmd := exec.Command("apt-mark", append([]string{"hold"}, packages...)...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+func (i *Installer) APTHold(ctx context.Context, packages ...string) error {
+	slog.Info("apt-mark hold", "packages", packages)
+	if err := i.Runner.Run(ctx, Cmd{
+		Name: "apt-mark",
+		Args: append([]string{"hold"}, packages...),
+	}); err != nil {
 		return errors.Wrap(err, "apt-mark hold")
 	}
 	return nil
 }
 
-func APTKey(keyName, keyURL string) error {
-	fmt.Printf("> Adding GPG key %s\n", keyName)
-	dirName := "/etc/apt/keyrings"
-	if _, err := os.Stat(dirName); os.IsNotExist(err) {
-		fmt.Println("> Creating", dirName)
-		if err := os.Mkdir(dirName, 0750); err != nil {
+func (i *Installer) APTUnhold(ctx context.Context, packages ...string) error {
+	slog.Info("apt-mark unhold", "packages", packages)
+	if err := i.Runner.Run(ctx, Cmd{
+		Name: "apt-mark",
+		Args: append([]string{"unhold"}, packages...),
+	}); err != nil {
+		return errors.Wrap(err, "apt-mark unhold")
+	}
+	return nil
+}
+
+// K8sRepo adds kubernetes repository for minor version, like v1.31.
+//
+// Repository is replaced if it is already added for other version.
+func (i *Installer) K8sRepo(ctx context.Context, version string) error {
+	// Same key is used for all versions.
+	if err := i.APTKey(ctx, "k8s", "https://pkgs.k8s.io/core:/stable:/"+version+"/deb/Release.key"); err != nil {
+		return errors.Wrap(err, "add k8s key")
+	}
+	if err := i.APTAddRepo(ctx, APTAddRepoOptions{
+		Name:       "k8s",
+		URL:        "https://pkgs.k8s.io/core:/stable:/" + version + "/deb/",
+		SignedBy:   aptKeyPath("k8s"),
+		Components: []string{"/"},
+	}); err != nil {
+		return errors.Wrap(err, "add k8s repo")
+	}
+	return nil
+}
+
+const aptKeyringsDir = "/etc/apt/keyrings"
+
+func aptKeyPath(name string) string {
+	return filepath.Join(aptKeyringsDir, name+".gpg")
+}
+
+func aptListPath(name string) string {
+	return filepath.Join("/etc/apt/sources.list.d", name+".list")
+}
+
+func (i *Installer) APTKey(ctx context.Context, keyName, keyURL string) error {
+	slog.Info("Adding GPG key", "key", keyName)
+	dirName := aptKeyringsDir
+	if _, err := i.Runner.Stat(dirName); os.IsNotExist(err) {
+		slog.Info("Creating directory", "path", dirName)
+		if err := i.Runner.MkdirAll(dirName, 0750); err != nil {
 			return errors.Wrap(err, "mkdir")
 		}
 	}
-	fileName := filepath.Join(dirName, keyName+".gpg")
-	if _, err := os.Stat(fileName); err == nil {
-		fmt.Printf("> GPG key %s already exists\n", fileName)
+	fileName := aptKeyPath(keyName)
+	if _, err := i.Runner.Stat(fileName); err == nil {
+		slog.Info("GPG key already exists", "path", fileName)
 		return nil
 	}
-	fmt.Println("Downloading key", keyURL)
-	res, err := http.Get(keyURL)
-	if err != nil {
+	slog.Info("Downloading key", "url", keyURL)
+	var data bytes.Buffer
+	if err := retry(ctx, "download key", func() error {
+		data.Reset()
+		return httpGet(ctx, keyURL, &data)
+	}); err != nil {
 		return errors.Wrap(err, "get key")
 	}
-	defer func() {
-		_ = res.Body.Close()
-	}()
-	if res.StatusCode != http.StatusOK {
-		return errors.Errorf("bad status: %s", res.Status)
-	}
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		return errors.Wrap(err, "read key")
-	}
-	fmt.Printf("> Writing %s\n", fileName)
-	cmd := exec.Command("gpg", "--dearmour", "-o", fileName)
-	cmd.Stdin = bytes.NewReader(data)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+	slog.Info("Writing file", "path", fileName)
+	if err := i.Runner.Run(ctx, Cmd{
+		Name:  "gpg",
+		Args:  []string{"--dearmour", "-o", fileName},
+		Stdin: &data,
+	}); err != nil {
 		return errors.Wrap(err, "gpg")
 	}
 	return nil
@@ -112,8 +145,8 @@ type APTAddRepoOptions struct {
 	Components []string
 }
 
-func APTAddRepo(opt APTAddRepoOptions) error {
-	// sudo add-apt-repository "deb [arch=amd64] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable"
+func (i *Installer) APTAddRepo(ctx context.Context, opt APTAddRepoOptions) error {
+	// sudo add-apt-repository "deb [arch=amd64] https://download.docker.com/linux/ubuntu noble stable"
 	// deb [arch=amd64,arm64,armhf] https://packages.microsoft.com/repos/code stable main
 	// deb [signed-by=/etc/apt/keyrings/kubernetes-apt-keyring.gpg] https://pkgs.k8s.io/core:/stable:/v1.32/deb/ /
 	// deb [arch=amd64 signed-by=/usr/share/keyrings/some-repo.gpg] http://some.repo/apt dev main
@@ -140,9 +173,8 @@ func APTAddRepo(opt APTAddRepoOptions) error {
 	s.WriteString("\n")
 
 	// Write to file.
-	fileName := filepath.Join("/etc/apt/sources.list.d", opt.Name+".list")
-	fmt.Printf("> Writing %s\n", fileName)
-	if err := os.WriteFile(fileName, []byte(s.String()), 0600); err != nil {
+	fileName := aptListPath(opt.Name)
+	if _, err := i.writeFile(fileName, []byte(s.String()), 0600); err != nil {
 		return errors.Wrap(err, "write")
 	}
 
@@ -0,0 +1,31 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+)
+
+// Arch detects node architecture in Debian naming, e.g. amd64 or arm64,
+// which is also used by helm and cilium release archives.
+func (i *Installer) Arch(ctx context.Context) (string, error) {
+	out, err := i.Runner.Output(ctx, Cmd{Name: "dpkg", Args: []string{"--print-architecture"}})
+	if err != nil {
+		return "", errors.Wrap(err, "dpkg")
+	}
+	arch := string(bytes.TrimSpace(out))
+	if arch == "" {
+		// Dry run on node without dpkg, ki is built for node architecture.
+		arch = runtime.GOARCH
+	}
+	if !slices.Contains(config.Architectures, arch) {
+		return "", errors.Errorf("unsupported architecture %q (supported: %s)", arch, strings.Join(config.Architectures, ", "))
+	}
+	return arch, nil
+}
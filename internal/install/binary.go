@@ -1,31 +1,41 @@
 package install
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"io"
-	"net/http"
+	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
 
 	"github.com/go-faster/errors"
 )
 
 type Binary struct {
-	URL    string
-	Name   string
+	URL  string
+	Name string
+	// SHA256 is required pinned checksum of archive. Checksum published
+	// with release is not used, as it comes from the same origin.
 	SHA256 string
 }
 
+func binaryPath(name string) string {
+	return "/usr/local/bin/" + name
+}
+
 // InstallBinary installs a binary to machine.
-func InstallBinary(bin Binary) error {
-	fmt.Println("> Install binary", bin.Name)
-	targetBinaryPath := "/usr/local/bin/" + bin.Name
-	if _, err := os.Stat(targetBinaryPath); err == nil {
-		fmt.Println("> Binary already exists")
+func (i *Installer) InstallBinary(ctx context.Context, bin Binary) error {
+	slog.Info("Install binary", "binary", bin.Name)
+	targetBinaryPath := binaryPath(bin.Name)
+	if _, err := i.Runner.Stat(targetBinaryPath); err == nil {
+		slog.Info("Binary already exists", "path", targetBinaryPath)
 		return nil
 	}
+	expectedSHA256 := bin.SHA256
+	if expectedSHA256 == "" {
+		return errors.Errorf("checksum of %s is not pinned", bin.Name)
+	}
 	// 1. Download to tmp.
 	baseName := filepath.Base(bin.URL)
 	workDir, err := os.MkdirTemp("", "ki-dl-")
@@ -36,42 +46,33 @@ func InstallBinary(bin Binary) error {
 	if err != nil {
 		return errors.Wrap(err, "create temp")
 	}
-	{
+	slog.Info("Downloading", "url", bin.URL)
+	if err := retry(ctx, "download "+bin.Name, func() error {
+		// Truncating partial download from previous attempt.
 		f, err := os.Create(targetName)
 		if err != nil {
-			return errors.Wrap(err, "create temp")
+			return permanent(errors.Wrap(err, "create temp"))
 		}
 		defer func() {
 			_ = f.Close()
-			_ = os.Remove(f.Name())
 		}()
-		fmt.Println("> Downloading", bin.URL)
-		res, err := http.Get(bin.URL)
-		if err != nil {
-			return errors.Wrap(err, "get")
-		}
-		defer func() {
-			_ = res.Body.Close()
-		}()
-		if res.StatusCode != http.StatusOK {
-			return errors.Errorf("bad status: %s", res.Status)
-		}
-		if _, err := io.Copy(f, res.Body); err != nil {
-			return errors.Wrap(err, "copy")
-		}
-		if err := f.Close(); err != nil {
-			return errors.Wrap(err, "close")
+		if err := httpGet(ctx, bin.URL, f); err != nil {
+			return err
 		}
+		return f.Close()
+	}); err != nil {
+		return errors.Wrap(err, "download")
 	}
 	var binaryPath string
 	{
 		// Unpack.
-		fmt.Println("> Unpacking")
-		cmd := exec.Command("tar", "-xzf", baseName)
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
-		cmd.Dir = workDir
-		if err := cmd.Run(); err != nil {
+		// Unpacking to temporary directory does not change node state.
+		slog.Info("Unpacking", "archive", baseName)
+		if _, err := i.Runner.Output(ctx, Cmd{
+			Name: "tar",
+			Args: []string{"-xzf", baseName},
+			Dir:  workDir,
+		}); err != nil {
 			return errors.Wrap(err, "tar")
 		}
 		// Now find a binary in directory, recursively.
@@ -97,7 +98,7 @@ func InstallBinary(bin Binary) error {
 	}
 	{
 		// 2. Check SHA256.
-		fmt.Println("> Checking SHA256")
+		slog.Info("Checking SHA256")
 		h := sha256.New()
 		f, err := os.Open(targetName)
 		if err != nil {
@@ -109,16 +110,16 @@ func InstallBinary(bin Binary) error {
 		if _, err := io.Copy(h, f); err != nil {
 			return errors.Wrap(err, "copy")
 		}
-		if got := fmt.Sprintf("%x", h.Sum(nil)); got != bin.SHA256 {
+		if got := fmt.Sprintf("%x", h.Sum(nil)); got != expectedSHA256 {
 			return errors.Errorf("bad sha256: %s", got)
 		}
-		fmt.Println("> SHA256 OK")
+		slog.Info("SHA256 OK")
 	}
 	// Install with chmod +x
-	cmd := exec.Command("install", "-m", "0755", binaryPath, targetBinaryPath)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+	if err := i.Runner.Run(ctx, Cmd{
+		Name: "install",
+		Args: []string{"-m", "0755", binaryPath, targetBinaryPath},
+	}); err != nil {
 		return errors.Wrap(err, "install")
 	}
 	return nil
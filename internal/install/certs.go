@@ -0,0 +1,154 @@
+package install
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-faster/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ernado/ki/internal/pki"
+)
+
+const kubernetesDir = "/etc/kubernetes"
+
+// Certificate is certificate of cluster component or kubeconfig.
+type Certificate struct {
+	// Name is file name relative to /etc/kubernetes, with kubeconfig user
+	// name for kubeconfigs, like "pki/apiserver.crt" or "admin.conf".
+	Name     string    `json:"name"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"notAfter"`
+	CA       bool      `json:"ca"`
+	// Expiring is true if certificate expires within expiry window.
+	Expiring bool `json:"expiring"`
+}
+
+// kubeconfigCertificates returns client certificates of kubeconfig, either
+// embedded or referenced by path.
+func (i *Installer) kubeconfigCertificates(name string) ([]*x509.Certificate, error) {
+	data, err := i.Runner.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	var kubeconfig struct {
+		Users []struct {
+			User struct {
+				ClientCertificate     string `yaml:"client-certificate"`
+				ClientCertificateData string `yaml:"client-certificate-data"`
+			} `yaml:"user"`
+		} `yaml:"users"`
+	}
+	if err := yaml.Unmarshal(data, &kubeconfig); err != nil {
+		return nil, errors.Wrap(err, "unmarshal")
+	}
+	var certs []*x509.Certificate
+	for _, u := range kubeconfig.Users {
+		var certPEM []byte
+		switch {
+		case u.User.ClientCertificateData != "":
+			if certPEM, err = base64.StdEncoding.DecodeString(u.User.ClientCertificateData); err != nil {
+				return nil, errors.Wrap(err, "decode client certificate")
+			}
+		case u.User.ClientCertificate != "":
+			// Rotated by kubelet, like /var/lib/kubelet/pki/kubelet-client-current.pem.
+			if certPEM, err = i.Runner.ReadFile(u.User.ClientCertificate); err != nil {
+				return nil, errors.Wrap(err, "read client certificate")
+			}
+		default:
+			continue
+		}
+		cert, err := pki.ParseCertificate(certPEM)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse client certificate")
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// Certificates returns certificates in /etc/kubernetes/pki and client
+// certificates of kubeconfigs in /etc/kubernetes, sorted by expiry.
+func (i *Installer) Certificates(window time.Duration) ([]Certificate, error) {
+	var (
+		result []Certificate
+		now    = time.Now()
+	)
+	add := func(name string, cert *x509.Certificate) {
+		result = append(result, Certificate{
+			Name:     name,
+			Subject:  cert.Subject.String(),
+			NotAfter: cert.NotAfter,
+			CA:       cert.IsCA,
+			Expiring: cert.NotAfter.Sub(now) < window,
+		})
+	}
+	var files []string
+	for _, pattern := range []string{"pki/*.crt", "pki/etcd/*.crt"} {
+		matches, err := filepath.Glob(filepath.Join(kubernetesDir, pattern))
+		if err != nil {
+			return nil, errors.Wrap(err, "glob")
+		}
+		files = append(files, matches...)
+	}
+	for _, name := range files {
+		data, err := i.Runner.ReadFile(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", name)
+		}
+		cert, err := pki.ParseCertificate(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s", name)
+		}
+		rel, _ := filepath.Rel(kubernetesDir, name)
+		add(rel, cert)
+	}
+	kubeconfigs, err := filepath.Glob(filepath.Join(kubernetesDir, "*.conf"))
+	if err != nil {
+		return nil, errors.Wrap(err, "glob")
+	}
+	for _, name := range kubeconfigs {
+		certs, err := i.kubeconfigCertificates(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "kubeconfig %s", name)
+		}
+		for _, cert := range certs {
+			add(filepath.Base(name), cert)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.Errorf("no certificates found in %s", kubernetesDir)
+	}
+	sort.SliceStable(result, func(a, b int) bool {
+		return result[a].NotAfter.Before(result[b].NotAfter)
+	})
+	return result, nil
+}
+
+// RenewCertificates renews certificates issued by kubeadm on control plane
+// node, restarts control plane static pods and refreshes user kubeconfig.
+//
+// CA certificates and kubelet client certificate are not renewed, kubelet
+// rotates its certificate itself.
+func (i *Installer) RenewCertificates(ctx context.Context) error {
+	if !i.exists(kubeAPIServerManifest) {
+		return errors.New("certificates can be renewed only on control plane node")
+	}
+	slog.Info("Renewing certificates")
+	if err := i.Runner.Run(ctx, Cmd{Name: "kubeadm", Args: []string{"certs", "renew", "all"}}); err != nil {
+		return errors.Wrap(err, "kubeadm certs renew")
+	}
+	if err := i.restartStaticPods(ctx, controlPlanePods...); err != nil {
+		return errors.Wrap(err, "restart static pods")
+	}
+	// User kubeconfig is a copy of admin.conf with old client certificate.
+	if err := i.SetupKubeconfig(ctx); err != nil {
+		return errors.Wrap(err, "setup kubeconfig")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-faster/errors"
+)
+
+// osReleasePath is path to os-release file, see os-release(5).
+const osReleasePath = "/etc/os-release"
+
+// Distro is supported Linux distribution release.
+type Distro struct {
+	ID       string // ubuntu
+	Codename string // noble
+	// DockerRepo is URL of Docker APT repository that provides containerd.
+	DockerRepo string
+	// Dependencies are packages required to add APT repositories.
+	Dependencies []string
+	// Containerd is name of containerd package.
+	Containerd string
+}
+
+func (d Distro) String() string {
+	return d.ID + " " + d.Codename
+}
+
+func ubuntu(codename string) Distro {
+	return Distro{
+		ID:           "ubuntu",
+		Codename:     codename,
+		DockerRepo:   "https://download.docker.com/linux/ubuntu",
+		Dependencies: []string{"curl", "gnupg2", "software-properties-common", "apt-transport-https", "ca-certificates"},
+		Containerd:   "containerd.io",
+	}
+}
+
+func debian(codename string) Distro {
+	return Distro{
+		ID:         "debian",
+		Codename:   codename,
+		DockerRepo: "https://download.docker.com/linux/debian",
+		// No software-properties-common since trixie, and
+		// apt-transport-https is part of apt.
+		Dependencies: []string{"curl", "gnupg", "ca-certificates"},
+		Containerd:   "containerd.io",
+	}
+}
+
+var distros = []Distro{
+	ubuntu("jammy"),
+	ubuntu("noble"),
+	debian("bookworm"),
+	debian("trixie"),
+}
+
+func supportedDistros() string {
+	names := make([]string, 0, len(distros))
+	for _, d := range distros {
+		names = append(names, d.String())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// parseOSRelease parses os-release file into key-value pairs.
+func parseOSRelease(data []byte) map[string]string {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(v); err == nil {
+			v = unquoted
+		} else {
+			v = strings.Trim(v, `'"`)
+		}
+		vars[k] = v
+	}
+	return vars
+}
+
+// Distro detects distribution of node from os-release.
+func (i *Installer) Distro() (Distro, error) {
+	data, err := i.Runner.ReadFile(osReleasePath)
+	if err != nil {
+		return Distro{}, errors.Wrap(err, "read os-release")
+	}
+	vars := parseOSRelease(data)
+	id, codename := vars["ID"], vars["VERSION_CODENAME"]
+	if codename == "" {
+		codename = vars["UBUNTU_CODENAME"]
+	}
+	for _, d := range distros {
+		if d.ID == id && d.Codename == codename {
+			return d, nil
+		}
+	}
+	return Distro{}, errors.Errorf("unsupported OS %q %q (supported: %s)", id, codename, supportedDistros())
+}
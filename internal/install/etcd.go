@@ -0,0 +1,347 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"log/slog"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-faster/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ernado/ki/internal/config"
+	"github.com/ernado/ki/internal/s3"
+)
+
+const (
+	// etcdSnapshotPath is in etcd data directory, that is mounted to
+	// etcd pod, so snapshot saved in pod is available on node.
+	etcdSnapshotPath = "/var/lib/etcd/ki-snapshot.db"
+	// etcdRestorePath is snapshot to restore, /var/lib is mounted to
+	// restore container.
+	etcdRestorePath = "/var/lib/ki/etcd-restore.db"
+
+	etcdManifest = "/etc/kubernetes/manifests/etcd.yaml"
+
+	backupServicePath = "/etc/systemd/system/ki-backup.service"
+	backupTimerPath   = "/etc/systemd/system/ki-backup.timer"
+)
+
+// s3Client creates client for bucket, reading credentials from node.
+func (i *Installer) s3Client(cfg config.S3) (*s3.Client, error) {
+	data, err := i.Runner.ReadFile(cfg.CredentialsPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read credentials")
+	}
+	c := &s3.Client{
+		Endpoint: cfg.Endpoint,
+		Region:   cfg.Region,
+		Bucket:   cfg.Bucket,
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		k, v, _ := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		switch k {
+		case "AWS_ACCESS_KEY_ID":
+			c.AccessKeyID = v
+		case "AWS_SECRET_ACCESS_KEY":
+			c.SecretAccessKey = v
+		}
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return nil, errors.Errorf("AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY not found in %s", cfg.CredentialsPath)
+	}
+	return c, nil
+}
+
+type EtcdBackupOptions struct {
+	// NodeName is name of control plane node, used to find etcd pod.
+	NodeName    string
+	Kubeconfig  string
+	ClusterName string
+	S3          config.S3
+	// Keep is number of latest snapshots kept in bucket.
+	Keep int
+	// Output saves snapshot to local file instead of uploading it.
+	Output string
+}
+
+// snapshotTimeFormat is format of snapshot time in object key.
+const snapshotTimeFormat = "20060102T150405Z"
+
+// reSnapshotName matches snapshot name after prefix, see snapshotTimeFormat.
+var reSnapshotName = regexp.MustCompile(`^\d{8}T\d{6}Z\.db$`)
+
+// snapshotPrefix returns key prefix of cluster snapshots.
+//
+// Cluster name is a directory, so snapshots of cluster "ki" and
+// "ki-staging" do not share prefix.
+func snapshotPrefix(cfg config.S3, clusterName string) string {
+	return cfg.Prefix + clusterName + "/"
+}
+
+// expiredSnapshots returns snapshots to delete so that only keep latest
+// ones remain. Objects not named by BackupEtcd are ignored.
+func expiredSnapshots(objects []s3.Object, prefix string, keep int) []s3.Object {
+	var snapshots []s3.Object
+	for _, o := range objects {
+		if name, ok := strings.CutPrefix(o.Key, prefix); ok && reSnapshotName.MatchString(name) {
+			snapshots = append(snapshots, o)
+		}
+	}
+	// Keys are sorted, so by time.
+	if len(snapshots) <= keep {
+		return nil
+	}
+	return snapshots[:len(snapshots)-keep]
+}
+
+// BackupEtcd saves etcd snapshot and uploads it to S3, deleting old snapshots.
+func (i *Installer) BackupEtcd(ctx context.Context, opt EtcdBackupOptions) error {
+	slog.Info("Saving etcd snapshot", "node", opt.NodeName)
+	if err := i.Runner.Run(ctx, kubectl(opt.Kubeconfig,
+		"exec", "--namespace", "kube-system", "etcd-"+opt.NodeName, "--",
+		"etcdctl",
+		"--endpoints", "https://127.0.0.1:2379",
+		"--cacert", "/etc/kubernetes/pki/etcd/ca.crt",
+		"--cert", "/etc/kubernetes/pki/etcd/server.crt",
+		"--key", "/etc/kubernetes/pki/etcd/server.key",
+		"snapshot", "save", etcdSnapshotPath,
+	)); err != nil {
+		return errors.Wrap(err, "etcdctl snapshot save")
+	}
+	defer func() {
+		_ = i.Runner.RemoveAll(etcdSnapshotPath)
+	}()
+	if opt.Output != "" {
+		slog.Info("Copying snapshot", "path", opt.Output)
+		if err := i.Runner.Run(ctx, Cmd{Name: "install", Args: []string{"-m", "0600", etcdSnapshotPath, opt.Output}}); err != nil {
+			return errors.Wrap(err, "copy snapshot")
+		}
+		return nil
+	}
+	if opt.S3.Bucket == "" {
+		return errors.New("backup.s3.bucket is not set")
+	}
+	prefix := snapshotPrefix(opt.S3, opt.ClusterName)
+	key := prefix + time.Now().UTC().Format(snapshotTimeFormat) + ".db"
+	if i.dryRun() {
+		slog.Info("Would upload snapshot", "bucket", opt.S3.Bucket, "key", key)
+		return nil
+	}
+	client, err := i.s3Client(opt.S3)
+	if err != nil {
+		return errors.Wrap(err, "s3 client")
+	}
+	data, err := i.Runner.ReadFile(etcdSnapshotPath)
+	if err != nil {
+		return errors.Wrap(err, "read snapshot")
+	}
+	slog.Info("Uploading snapshot", "bucket", opt.S3.Bucket, "key", key, "size", len(data))
+	if err := retry(ctx, "upload snapshot", func() error {
+		return client.Put(ctx, key, bytes.NewReader(data))
+	}); err != nil {
+		return errors.Wrap(err, "upload")
+	}
+
+	objects, err := client.List(ctx, prefix)
+	if err != nil {
+		return errors.Wrap(err, "list snapshots")
+	}
+	for _, old := range expiredSnapshots(objects, prefix, opt.Keep) {
+		slog.Info("Deleting old snapshot", "key", old.Key)
+		if err := client.Delete(ctx, old.Key); err != nil {
+			return errors.Wrap(err, "delete old snapshot")
+		}
+	}
+	return nil
+}
+
+//go:embed ki-backup.service
+var backupService string
+
+//go:embed ki-backup.timer.tmpl
+var backupTimerTemplate string
+
+// EtcdBackupTimer installs systemd timer that runs "ki backup etcd" on schedule.
+func (i *Installer) EtcdBackupTimer(ctx context.Context, schedule string) error {
+	tmpl, err := template.New("ki-backup.timer").Parse(backupTimerTemplate)
+	if err != nil {
+		return errors.Wrap(err, "parse template")
+	}
+	var timer bytes.Buffer
+	if err := tmpl.Execute(&timer, struct{ Schedule string }{schedule}); err != nil {
+		return errors.Wrap(err, "execute template")
+	}
+	serviceChanged, err := i.writeFile(backupServicePath, []byte(backupService), 0600)
+	if err != nil {
+		return errors.Wrap(err, "write ki-backup.service")
+	}
+	timerChanged, err := i.writeFile(backupTimerPath, timer.Bytes(), 0600)
+	if err != nil {
+		return errors.Wrap(err, "write ki-backup.timer")
+	}
+	if serviceChanged || timerChanged {
+		if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"daemon-reload"}}); err != nil {
+			return errors.Wrap(err, "daemon-reload")
+		}
+	}
+	if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"enable", "--now", "ki-backup.timer"}}); err != nil {
+		return errors.Wrap(err, "enable ki-backup.timer")
+	}
+	return nil
+}
+
+type EtcdRestoreOptions struct {
+	// Snapshot is local file or object key, with or without S3 prefix.
+	Snapshot string
+	S3       config.S3
+	// ControlPlaneCount is number of control plane nodes, only single
+	// control plane node is supported.
+	ControlPlaneCount int
+}
+
+// etcdMember is etcd configuration from static pod manifest.
+type etcdMember struct {
+	Image    string
+	Name     string
+	PeerURL  string
+	DataDir  string
+	Manifest []byte
+}
+
+func (i *Installer) etcdMember() (*etcdMember, error) {
+	data, err := i.Runner.ReadFile(etcdManifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "read manifest")
+	}
+	var pod struct {
+		Spec struct {
+			Containers []struct {
+				Image   string   `yaml:"image"`
+				Command []string `yaml:"command"`
+			} `yaml:"containers"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(data, &pod); err != nil {
+		return nil, errors.Wrap(err, "unmarshal manifest")
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return nil, errors.New("no containers in manifest")
+	}
+	c := pod.Spec.Containers[0]
+	m := &etcdMember{Image: c.Image, DataDir: "/var/lib/etcd", Manifest: data}
+	for _, arg := range c.Command {
+		k, v, _ := strings.Cut(arg, "=")
+		switch k {
+		case "--name":
+			m.Name = v
+		case "--initial-advertise-peer-urls":
+			m.PeerURL = v
+		case "--data-dir":
+			m.DataDir = v
+		}
+	}
+	if m.Image == "" || m.Name == "" || m.PeerURL == "" {
+		return nil, errors.New("image, name or peer URL not found in manifest")
+	}
+	return m, nil
+}
+
+// RestoreEtcd restores etcd of single control plane node from snapshot.
+//
+// API server and etcd are stopped during restore, current etcd data
+// directory is kept with .bak suffix.
+func (i *Installer) RestoreEtcd(ctx context.Context, opt EtcdRestoreOptions) error {
+	if opt.ControlPlaneCount > 1 {
+		return errors.New("restore is supported only for single control plane node, " +
+			"see https://etcd.io/docs/v3.5/op-guide/recovery/ for multiple nodes")
+	}
+	member, err := i.etcdMember()
+	if err != nil {
+		return errors.Wrap(err, "etcd member")
+	}
+	if err := i.Runner.MkdirAll(path.Dir(etcdRestorePath), 0750); err != nil {
+		return errors.Wrap(err, "mkdir")
+	}
+	if i.exists(opt.Snapshot) {
+		slog.Info("Using local snapshot", "path", opt.Snapshot)
+		if err := i.Runner.Run(ctx, Cmd{Name: "install", Args: []string{"-m", "0600", opt.Snapshot, etcdRestorePath}}); err != nil {
+			return errors.Wrap(err, "copy snapshot")
+		}
+	} else {
+		if opt.S3.Bucket == "" {
+			return errors.Errorf("snapshot %s not found and backup.s3.bucket is not set", opt.Snapshot)
+		}
+		client, err := i.s3Client(opt.S3)
+		if err != nil {
+			return errors.Wrap(err, "s3 client")
+		}
+		key := opt.Snapshot
+		if !strings.HasPrefix(key, opt.S3.Prefix) {
+			key = opt.S3.Prefix + key
+		}
+		slog.Info("Downloading snapshot", "bucket", opt.S3.Bucket, "key", key)
+		var data bytes.Buffer
+		if err := retry(ctx, "download snapshot", func() error {
+			data.Reset()
+			return client.Get(ctx, key, &data)
+		}); err != nil {
+			return errors.Wrap(err, "download")
+		}
+		if err := i.Runner.WriteFile(etcdRestorePath, data.Bytes(), 0600); err != nil {
+			return errors.Wrap(err, "write snapshot")
+		}
+	}
+	defer func() {
+		_ = i.Runner.RemoveAll(etcdRestorePath)
+	}()
+
+	slog.Info("Stopping API server and etcd")
+	if err := i.stopStaticPods(ctx, "etcd", "kube-apiserver"); err != nil {
+		return errors.Wrap(err, "stop static pods")
+	}
+
+	restoreDir := member.DataDir + "-restore"
+	if err := i.Runner.RemoveAll(restoreDir); err != nil {
+		return errors.Wrap(err, "remove restore dir")
+	}
+	slog.Info("Restoring snapshot", "image", member.Image, "name", member.Name)
+	if err := i.Runner.Run(ctx, Cmd{
+		Name: "ctr",
+		Args: []string{
+			"--namespace", "k8s.io",
+			"run", "--rm",
+			"--mount", "type=bind,src=/var/lib,dst=/var/lib,options=rbind:rw",
+			member.Image, "ki-etcd-restore",
+			"etcdutl", "snapshot", "restore", etcdRestorePath,
+			"--name", member.Name,
+			"--initial-cluster", member.Name + "=" + member.PeerURL,
+			"--initial-advertise-peer-urls", member.PeerURL,
+			"--data-dir", restoreDir,
+		},
+	}); err != nil {
+		return errors.Wrap(err, "etcdutl snapshot restore")
+	}
+	backupDir := member.DataDir + ".bak-" + time.Now().UTC().Format("20060102T150405Z")
+	slog.Info("Replacing etcd data", "data_dir", member.DataDir, "backup", backupDir)
+	if err := i.Runner.Run(ctx, Cmd{Name: "mv", Args: []string{member.DataDir, backupDir}}); err != nil {
+		return errors.Wrap(err, "move data dir")
+	}
+	if err := i.Runner.Run(ctx, Cmd{Name: "mv", Args: []string{restoreDir, member.DataDir}}); err != nil {
+		return errors.Wrap(err, "move restored data dir")
+	}
+
+	slog.Info("Starting API server and etcd")
+	if err := i.startStaticPods(ctx, "etcd", "kube-apiserver"); err != nil {
+		return errors.Wrap(err, "start static pods")
+	}
+	slog.Info("Restored etcd", "backup", backupDir)
+	return nil
+}
@@ -0,0 +1,41 @@
+package install
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ernado/ki/internal/config"
+	"github.com/ernado/ki/internal/s3"
+)
+
+func TestExpiredSnapshots(t *testing.T) {
+	prefix := snapshotPrefix(config.S3{Prefix: "etcd/"}, "ki")
+	if prefix != "etcd/ki/" {
+		t.Fatalf("unexpected prefix %s", prefix)
+	}
+	var objects []s3.Object
+	for _, key := range []string{
+		"etcd/ki/20250101T000000Z.db",
+		"etcd/ki/20250102T000000Z.db",
+		"etcd/ki/20250103T000000Z.db",
+		"etcd/ki/manual.db",
+		"etcd/ki/staging/20250101T000000Z.db",
+		"etcd/ki/20250104T000000Z.db",
+	} {
+		objects = append(objects, s3.Object{Key: key})
+	}
+	var got []string
+	for _, o := range expiredSnapshots(objects, prefix, 2) {
+		got = append(got, o.Key)
+	}
+	expected := []string{
+		"etcd/ki/20250101T000000Z.db",
+		"etcd/ki/20250102T000000Z.db",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+	if got := expiredSnapshots(objects, prefix, 4); got != nil {
+		t.Errorf("got %v, expected none", got)
+	}
+}
@@ -0,0 +1,82 @@
+package install
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// EventsPath is default path to install event stream.
+const EventsPath = "/var/lib/ki/events.jsonl"
+
+type EventType string
+
+const (
+	EventStepStart  EventType = "step_start"
+	EventStepFinish EventType = "step_finish"
+	EventStepFail   EventType = "step_fail"
+	EventStepSkip   EventType = "step_skip"
+)
+
+// Event is install event, written as single JSON line.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     EventType `json:"type"`
+	Step     string    `json:"step"`
+	Duration float64   `json:"duration_seconds,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Events is append-only JSON lines event stream.
+type Events struct {
+	mux sync.Mutex
+	f   *os.File
+}
+
+// OpenEvents opens event stream file for appending, creating it if needed.
+func OpenEvents(name string) (*Events, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0750); err != nil {
+		return nil, errors.Wrap(err, "mkdir")
+	}
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+	return &Events{f: f}, nil
+}
+
+// Emit writes event. Nil Events discards it.
+//
+// Failure to write event is logged and does not fail install.
+func (e *Events) Emit(ev Event) {
+	if e == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		slog.Warn("Failed to marshal event", "error", err)
+		return
+	}
+	data = append(data, '\n')
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if _, err := e.f.Write(data); err != nil {
+		slog.Warn("Failed to write event", "error", err)
+	}
+}
+
+// Close closes event stream file.
+func (e *Events) Close() error {
+	if e == nil {
+		return nil
+	}
+	return e.f.Close()
+}
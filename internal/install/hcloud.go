@@ -3,86 +3,126 @@ package install
 import (
 	"context"
 	"fmt"
-	"os"
-	"os/exec"
+	"log/slog"
 	"strings"
 	"time"
 
 	"github.com/go-faster/errors"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"gopkg.in/yaml.v3"
 )
 
-func HetznerCloudInstall() error {
+type HetznerCloudOptions struct {
+	// TokenPath is path to file with Hetzner Cloud API token.
+	TokenPath string
+	// Network is name of private network.
+	Network string
+	// CSI enables Hetzner Cloud CSI driver.
+	CSI bool
+	// CCM enables Hetzner Cloud Controller Manager, that initializes
+	// nodes and creates routes for PodCIDR in private network.
+	CCM     bool
+	PodCIDR string
+}
+
+// hccmValuesPath is relative to working directory, like ciliumValuesPath.
+const hccmValuesPath = "hccm.yml"
+
+func (i *Installer) HetznerCloudInstall(ctx context.Context, opt HetznerCloudOptions) error {
 	// https://community.hetzner.com/tutorials/kubernetes-on-hetzner-with-crio-flannel-and-hetzner-balancer#step-7---install-hetzner-cloud-controller
 	// https://github.com/hetznercloud/csi-driver/blob/main/docs/kubernetes/README.md#kubernetes-hetzner-cloud-csi-driver
 
-	fmt.Println("> Getting token")
-	token, err := os.ReadFile("/root/.hcloud")
+	slog.Info("Getting token", "path", opt.TokenPath)
+	token, err := i.Runner.ReadFile(opt.TokenPath)
 	if err != nil {
 		return errors.New("read hetzner cloud token")
 	}
 
-	fmt.Println("> Getting network ID")
+	slog.Info("Getting network ID", "network", opt.Network)
 	tokenStr := strings.TrimSpace(string(token))
 	client := hcloud.NewClient(hcloud.WithToken(tokenStr))
 	var networkID int64
 	{
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 		defer cancel()
 		networks, err := client.Network.All(ctx)
 		if err != nil {
 			return errors.Wrap(err, "get networks")
 		}
 		for _, network := range networks {
-			if network.Name == "kubernetes-cluster" {
+			if network.Name == opt.Network {
 				networkID = network.ID
 				break
 			}
 		}
 	}
 	if networkID == 0 {
-		return errors.New("network not found")
+		return errors.Errorf("network %q not found", opt.Network)
 	}
 
-	fmt.Println("> Creating namespace and secret for Hetzner constrollers")
+	slog.Info("Creating namespace and secret for Hetzner controllers")
 
 	const namespace = "hcloud"
 	{
 		// Create namespace.
-		cmd := exec.Command("kubectl", "create", "namespace", namespace)
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
-		if err := cmd.Run(); err != nil {
-			return errors.Wrap(err, "kubectl create namespace")
+		if err := i.KubectlCreateOrUpdate(ctx, "namespace", namespace); err != nil {
+			return errors.Wrap(err, "namespace")
 		}
 	}
 	{
-		cmd := exec.Command("kubectl", "create", "secret", "generic", "hcloud",
+		if err := i.KubectlCreateOrUpdate(ctx,
+			"secret", "generic", "hcloud",
 			"-n", namespace,
 			"--from-literal=token="+tokenStr,
 			"--from-literal=network="+fmt.Sprintf("%d", networkID),
-		)
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
-		if err := cmd.Run(); err != nil {
-			return errors.Wrap(err, "kubectl create secret")
+		); err != nil {
+			return errors.Wrap(err, "secret")
+		}
+	}
+	if opt.CSI || opt.CCM {
+		slog.Info("Installing Hetzner controllers")
+		if err := i.HelmAddRepo(ctx, "hcloud", "https://charts.hetzner.cloud"); err != nil {
+			return errors.Wrap(err, "helm repo add")
 		}
 	}
-	fmt.Println("> Installing Hetzner controllers")
-	if err := HelmAddRepo("hcloud", "https://charts.hetzner.cloud"); err != nil {
-		return errors.Wrap(err, "helm repo add")
+	if opt.CCM {
+		// https://github.com/hetznercloud/hcloud-cloud-controller-manager/blob/main/docs/guides/quickstart.md
+		slog.Info("Installing Hetzner cloud controller manager")
+		values, err := yaml.Marshal(map[string]any{
+			"networking": map[string]any{
+				"enabled":     true,
+				"clusterCIDR": opt.PodCIDR,
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "marshal values")
+		}
+		if _, err := i.writeFile(hccmValuesPath, values, 0600); err != nil {
+			return errors.Wrap(err, "write values")
+		}
+		if err := i.HelmUpgrade(ctx, HelmUpgradeOptions{
+			Chart:     "hcloud/hcloud-cloud-controller-manager",
+			Install:   true,
+			Namespace: namespace,
+			Name:      "hccm",
+			Values:    hccmValuesPath,
+		}); err != nil {
+			return errors.Wrap(err, "helm upgrade hccm")
+		}
 	}
-	fmt.Println("> Installing Hetzner cloud csi driver")
-	if err := HelmUpgrade(HelmUpgradeOptions{
-		Chart:     "hcloud/hcloud-csi",
-		Install:   true,
-		Namespace: namespace,
-		Name:      "hcsi",
-	}); err != nil {
-		return errors.Wrap(err, "helm upgrade")
+	if opt.CSI {
+		slog.Info("Installing Hetzner cloud csi driver")
+		if err := i.HelmUpgrade(ctx, HelmUpgradeOptions{
+			Chart:     "hcloud/hcloud-csi",
+			Install:   true,
+			Namespace: namespace,
+			Name:      "hcsi",
+		}); err != nil {
+			return errors.Wrap(err, "helm upgrade")
+		}
 	}
 
-	fmt.Println("> Hetzner support installed")
+	slog.Info("Hetzner support installed")
 
 	return nil
 }
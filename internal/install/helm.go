@@ -1,19 +1,20 @@
 package install
 
 import (
-	"fmt"
-	"os"
-	"os/exec"
+	"context"
+	"log/slog"
 
 	"github.com/go-faster/errors"
 )
 
-func HelmAddRepo(name, url string) error {
-	fmt.Printf("> helm repo add %s %s\n", name, url)
-	cmd := exec.Command("helm", "repo", "add", name, url)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+func (i *Installer) HelmAddRepo(ctx context.Context, name, url string) error {
+	slog.Info("helm repo add", "name", name, "url", url)
+	if err := retry(ctx, "helm repo add", func() error {
+		return i.Runner.Run(ctx, Cmd{
+			Name: "helm",
+			Args: []string{"repo", "add", "--force-update", name, url},
+		})
+	}); err != nil {
 		return errors.Wrap(err, "helm repo add")
 	}
 	return nil
@@ -31,8 +32,7 @@ type HelmUpgradeOptions struct {
 	KubeConfig      string
 }
 
-func HelmUpgrade(opt HelmUpgradeOptions) error {
-	fmt.Println("> helm: installing", opt.Name, opt.Chart)
+func (i *Installer) HelmUpgrade(ctx context.Context, opt HelmUpgradeOptions) error {
 	args := []string{
 		"upgrade",
 	}
@@ -55,14 +55,16 @@ func HelmUpgrade(opt HelmUpgradeOptions) error {
 		args = append(args, "--version", opt.Version)
 	}
 	args = append(args, opt.Name, opt.Chart)
-	fmt.Println("> helm upgrade", args)
-	cmd := exec.Command("helm", args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	slog.Info("helm upgrade", "release", opt.Name, "chart", opt.Chart, "args", args)
+	cmd := Cmd{Name: "helm", Args: args}
 	if opt.KubeConfig != "" {
-		cmd.Env = appendEnv(os.Environ(), "KUBECONFIG", opt.KubeConfig)
+		cmd.Env = appendEnv(cmd.Env, "KUBECONFIG", opt.KubeConfig)
 	}
-	if err := cmd.Run(); err != nil {
+	// Chart repository or API server may be temporarily unavailable,
+	// upgrade --install is safe to repeat.
+	if err := retry(ctx, "helm upgrade", func() error {
+		return i.Runner.Run(ctx, cmd)
+	}); err != nil {
 		return errors.Wrap(err, "helm upgrade")
 	}
 	return nil
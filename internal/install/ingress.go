@@ -1,19 +1,18 @@
 package install
 
 import (
+	"context"
 	_ "embed"
-	"os"
-	"os/exec"
 	"strings"
 )
 
 //go:embed ingress.yaml
 var ingressDefinition string
 
-func DefaultIngress() error {
-	cmd := exec.Command("kubectl", "apply", "-f", "-")
-	cmd.Stdin = strings.NewReader(ingressDefinition)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+func (i *Installer) DefaultIngress(ctx context.Context) error {
+	return i.Runner.Run(ctx, Cmd{
+		Name:  "kubectl",
+		Args:  []string{"apply", "-f", "-"},
+		Stdin: strings.NewReader(ingressDefinition),
+	})
 }
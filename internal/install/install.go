@@ -2,61 +2,100 @@
 package install
 
 import (
+	"bytes"
+	"context"
 	_ "embed"
-	"fmt"
+	"log/slog"
 	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/go-faster/errors"
 )
 
+// Installer performs install steps on node through Runner.
+type Installer struct {
+	Runner Runner
+	// Events receives step events, optional.
+	Events *Events
+}
+
+// New creates Installer that uses provided runner.
+func New(r Runner) *Installer {
+	return &Installer{Runner: r}
+}
+
+func (i *Installer) dryRun() bool {
+	_, ok := i.Runner.(*DryRunner)
+	return ok
+}
+
+// writeFile writes file only if its content differs,
+// reporting whether file was changed.
+func (i *Installer) writeFile(name string, data []byte, perm os.FileMode) (bool, error) {
+	if current, err := i.Runner.ReadFile(name); err == nil && bytes.Equal(current, data) {
+		slog.Info("File is up to date", "path", name)
+		return false, nil
+	}
+	slog.Info("Writing file", "path", name)
+	if err := i.Runner.WriteFile(name, data, perm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 //go:embed ki.service
 var kiService string
 
+const (
+	serviceConfigPath = "/etc/ki.conf"
+	servicePath       = "/etc/systemd/system/ki.service"
+)
+
 type ServiceOptions struct {
 	Join bool
+	// ControlPlane joins as control plane, used with Join.
+	ControlPlane bool
 }
 
-func Service(opt ServiceOptions) error {
+func (i *Installer) Service(ctx context.Context, opt ServiceOptions) error {
 	// Install as oneshot systemd service.
 	// Write to /etc/systemd/system/ki.service.
 	// Reload systemd.
 	// Enable and start ki.service.
 	// Service should be run in background.
-	fmt.Println("> Install ki service")
+	if !i.exists(kubeletKubeconfig) {
+		if err := CheckTCPPortIsFree(6443); err != nil {
+			return errors.Wrap(err, "check k8s port")
+		}
+	}
+	slog.Info("Install ki service")
 	{
 		// Create /etc/ki.conf with OPTIONS.
 		var b strings.Builder
 		b.WriteString("OPTIONS=")
-		if opt.Join {
-			b.WriteString("--join")
+		switch {
+		case opt.Join && opt.ControlPlane:
+			b.WriteString("join --control-plane")
+		case opt.Join:
+			b.WriteString("join")
+		default:
+			b.WriteString("init")
 		}
 		b.WriteString("\n")
-		if err := os.WriteFile("/etc/ki.conf", []byte(b.String()), 0600); err != nil {
+		if err := i.Runner.WriteFile(serviceConfigPath, []byte(b.String()), 0600); err != nil {
 			return errors.Wrap(err, "write ki.conf")
 		}
 	}
-	if err := os.WriteFile("/etc/systemd/system/ki.service", []byte(kiService), 0600); err != nil {
+	if err := i.Runner.WriteFile(servicePath, []byte(kiService), 0600); err != nil {
 		return errors.Wrap(err, "write ki.service")
 	}
-	{
-		cmd := exec.Command("systemctl", "daemon-reload")
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
-		if err := cmd.Run(); err != nil {
-			return errors.Wrap(err, "daemon-reload")
-		}
+	if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"daemon-reload"}}); err != nil {
+		return errors.Wrap(err, "daemon-reload")
 	}
-	{
-		cmd := exec.Command("systemctl", "start", "--no-block", "ki.service")
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
-		if err := cmd.Run(); err != nil {
-			return errors.Wrap(err, "start ki.service")
-		}
+	if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"start", "--no-block", "ki.service"}}); err != nil {
+		return errors.Wrap(err, "start ki.service")
 	}
-	fmt.Println("> ki service installed")
+	slog.Info("ki service installed")
 
 	return nil
 }
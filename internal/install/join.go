@@ -0,0 +1,225 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/pki"
+)
+
+const (
+	// joinTokenTTL limits lifetime of join tokens. Every join gets its
+	// own token, so it is only required to be valid during join.
+	joinTokenTTL = time.Hour
+	// joinTokenDescription marks tokens created by ki.
+	joinTokenDescription = "Created by ki for node join"
+	// bootstrapTokenDescription marks token pre-generated by ki-prepare-tf.
+	bootstrapTokenDescription = "Pre-generated by ki-prepare-tf"
+)
+
+// createJoinToken creates bootstrap token for joining node.
+func (i *Installer) createJoinToken(ctx context.Context) (string, error) {
+	token, err := pki.NewBootstrapToken()
+	if err != nil {
+		return "", errors.Wrap(err, "generate token")
+	}
+	if err := i.Runner.Run(ctx, Cmd{
+		Name: "kubeadm",
+		Args: []string{
+			"token", "create", token,
+			"--ttl", joinTokenTTL.String(),
+			"--description", joinTokenDescription,
+		},
+		// Token is printed back.
+		Stdout: io.Discard,
+		Redact: true,
+	}); err != nil {
+		return "", errors.Wrap(err, "kubeadm token create")
+	}
+	return token, nil
+}
+
+// clusterCA returns discovery hash and fingerprint of cluster CA certificate.
+func (i *Installer) clusterCA() (hash, fingerprint string, err error) {
+	if i.dryRun() && !i.exists(kubeadmCACertPath) {
+		return "<hash>", "<fingerprint>", nil
+	}
+	data, err := i.Runner.ReadFile(kubeadmCACertPath)
+	if err != nil {
+		return "", "", errors.Wrap(err, "read")
+	}
+	cert, err := pki.ParseCertificate(data)
+	if err != nil {
+		return "", "", errors.Wrap(err, "parse")
+	}
+	return pki.Fingerprint(cert), pki.CertificateFingerprint(cert), nil
+}
+
+// bootstrapToken is entry of "kubeadm token list -o json".
+type bootstrapToken struct {
+	Token       string    `json:"token"`
+	Description string    `json:"description"`
+	Expires     time.Time `json:"expires"`
+}
+
+// cleanupJoinTokens deletes expired tokens created by ki.
+//
+// Expired tokens are also deleted by token cleaner of controller manager,
+// but it can be disabled.
+func (i *Installer) cleanupJoinTokens(ctx context.Context) error {
+	out, err := i.Runner.Output(ctx, Cmd{
+		Name: "kubeadm",
+		Args: []string{"token", "list", "-o", "json"},
+	})
+	if err != nil {
+		return errors.Wrap(err, "kubeadm token list")
+	}
+	// Tokens are printed as stream of JSON objects.
+	d := json.NewDecoder(bytes.NewReader(out))
+	now := time.Now()
+	for {
+		var t bootstrapToken
+		if err := d.Decode(&t); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "decode")
+		}
+		if t.Description != joinTokenDescription || t.Expires.IsZero() || t.Expires.After(now) {
+			continue
+		}
+		// Token ID is not secret, unlike full token.
+		id, _, _ := strings.Cut(t.Token, ".")
+		slog.Info("Deleting expired join token", "id", id, "expires", t.Expires)
+		if err := i.Runner.Run(ctx, Cmd{
+			Name: "kubeadm",
+			Args: []string{"token", "delete", id},
+		}); err != nil {
+			return errors.Wrap(err, "kubeadm token delete")
+		}
+	}
+	return nil
+}
+
+// clusterInfoPath is cluster-info ConfigMap, readable anonymously and
+// signed by every bootstrap token, see kubeadm discovery.
+const clusterInfoPath = "/api/v1/namespaces/kube-public/configmaps/cluster-info"
+
+// bootstrapTokenActive reports whether bootstrap token still exists in
+// cluster, waiting for API server at addr, like 10.0.1.1:6443.
+//
+// Token is checked by its signature of cluster-info, that is removed by
+// controller manager along with expired token.
+func (i *Installer) bootstrapTokenActive(ctx context.Context, addr, token string) (bool, error) {
+	if i.dryRun() {
+		return true, nil
+	}
+	id, _, _ := strings.Cut(token, ".")
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				// Result only selects how to join: kubeadm verifies cluster
+				// CA on discovery and join server certificate is pinned.
+				InsecureSkipVerify: true, // #nosec G402
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+	// Control plane is created along with workers, so wait same as
+	// kubeadm discovery does.
+	p := retryPolicy(ctx)
+	if p.MaxElapsedTime > 0 && p.MaxElapsedTime < preGeneratedDiscoveryTimeout {
+		p.MaxElapsedTime = preGeneratedDiscoveryTimeout
+	}
+	var clusterInfo struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := retry(WithRetryPolicy(ctx, p), "get cluster-info", func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+addr+clusterInfoPath, http.NoBody)
+		if err != nil {
+			return permanent(errors.Wrap(err, "create request"))
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "get")
+		}
+		defer func() {
+			_ = res.Body.Close()
+		}()
+		if res.StatusCode != http.StatusOK {
+			// Not found or forbidden until kubeadm init completes.
+			return errors.Errorf("bad status: %s", res.Status)
+		}
+		if err := json.NewDecoder(res.Body).Decode(&clusterInfo); err != nil {
+			return errors.Wrap(err, "decode")
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	_, ok := clusterInfo.Data["jws-kubeconfig-"+id]
+	return ok, nil
+}
+
+// errNotInitialized is returned by JoinParams before kubeadm init.
+var errNotInitialized = errors.New("cluster is not initialized yet")
+
+type JoinParamsOptions struct {
+	// ControlPlane prepares join of control plane node, uploading
+	// certificates that are deleted by kubeadm after two hours.
+	ControlPlane bool
+}
+
+// JoinParams returns parameters for joining node to cluster with fresh token.
+//
+// Should be run on control plane node that initialized cluster.
+func (i *Installer) JoinParams(ctx context.Context, opt JoinParamsOptions) (InitParams, error) {
+	var params InitParams
+	data, err := i.Runner.ReadFile(initParamsPath)
+	if os.IsNotExist(err) {
+		return params, errNotInitialized
+	}
+	if err != nil {
+		return params, errors.Wrap(err, "read init params")
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return params, errors.Wrap(err, "unmarshal init params")
+	}
+	if err := i.cleanupJoinTokens(ctx); err != nil {
+		// Not critical for join.
+		slog.Warn("Failed to clean up join tokens", "error", err)
+	}
+	token, err := i.createJoinToken(ctx)
+	if err != nil {
+		return params, errors.Wrap(err, "create token")
+	}
+	// CA is read on every call for init params saved by older ki versions.
+	hash, fingerprint, err := i.clusterCA()
+	if err != nil {
+		return params, errors.Wrap(err, "cluster CA")
+	}
+	params.Token, params.Hash, params.CAFingerprint = token, hash, fingerprint
+	if opt.ControlPlane {
+		if params.CertificateKey == "" {
+			return params, errors.New("cluster is initialized without certificate key, set controlPlane.count > 1")
+		}
+		slog.Info("Uploading certificates")
+		if err := i.Runner.Run(ctx, uploadCertsCmd(params.CertificateKey)); err != nil {
+			return params, errors.Wrap(err, "upload certs")
+		}
+	} else {
+		// Worker should not be able to get control plane certificates.
+		params.CertificateKey = ""
+	}
+	return params, nil
+}
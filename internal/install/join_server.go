@@ -0,0 +1,282 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+	"github.com/ernado/ki/internal/pki"
+)
+
+// joinPath is join server endpoint, accepting joinRequest.
+const joinPath = "/v1/join"
+
+type joinRequest struct {
+	// ControlPlane requests certificate key for control plane join, allowed
+	// only with control plane join secret.
+	ControlPlane bool `json:"controlPlane"`
+}
+
+// joinHandler hands out join parameters to nodes presenting pre-shared secret.
+type joinHandler struct {
+	in     *Installer
+	secret string
+	// controlPlaneSecret is presented by joining control plane nodes, that
+	// get certificate key. Control plane join is disabled if empty.
+	controlPlaneSecret string
+	// mux serializes kubeadm invocations.
+	mux sync.Mutex
+}
+
+func secretEqual(a, b string) bool {
+	return b != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func (h *joinHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != joinPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	lg := slog.With("remote", r.RemoteAddr)
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	controlPlane := ok && secretEqual(secret, h.controlPlaneSecret)
+	if !controlPlane && (!ok || !secretEqual(secret, h.secret)) {
+		lg.Warn("Rejected join request with bad secret")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req joinRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.ControlPlane && !controlPlane {
+		// Worker secret is on every worker, certificate key gives access
+		// to cluster CA and must not be handed out for it.
+		lg.Warn("Rejected control plane join request without control plane secret")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	h.mux.Lock()
+	params, err := h.in.JoinParams(r.Context(), JoinParamsOptions{ControlPlane: req.ControlPlane})
+	h.mux.Unlock()
+	if errors.Is(err, errNotInitialized) {
+		// Joining node retries until cluster is initialized.
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		lg.Error("Failed to get join params", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	lg.Info("Handed out join params", "control_plane", req.ControlPlane)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(params)
+}
+
+type ServeJoinOptions struct {
+	// Addr to listen on, like 10.0.1.1:9444.
+	Addr       string
+	SecretPath string
+	// ControlPlaneSecretPath is path to control plane join secret, control
+	// plane join is disabled if file is missing.
+	ControlPlaneSecretPath string
+	CertPath               string
+	KeyPath                string
+}
+
+// readSecret reads secret, like pre-shared join secret or bootstrap token.
+func (i *Installer) readSecret(name string) (string, error) {
+	data, err := i.Runner.ReadFile(name)
+	if err != nil {
+		return "", errors.Wrap(err, "read")
+	}
+	secret := string(bytes.TrimSpace(data))
+	if secret == "" {
+		return "", errors.Errorf("%s is empty", name)
+	}
+	return secret, nil
+}
+
+// ServeJoin runs join server until context is canceled.
+//
+// Should be run on control plane node that initialized cluster.
+func (i *Installer) ServeJoin(ctx context.Context, opt ServeJoinOptions) error {
+	secret, err := i.readSecret(opt.SecretPath)
+	if err != nil {
+		return errors.Wrap(err, "join secret")
+	}
+	var controlPlaneSecret string
+	if opt.ControlPlaneSecretPath != "" && i.exists(opt.ControlPlaneSecretPath) {
+		if controlPlaneSecret, err = i.readSecret(opt.ControlPlaneSecretPath); err != nil {
+			return errors.Wrap(err, "control plane join secret")
+		}
+	} else {
+		slog.Warn("Control plane join secret is not provisioned, control plane nodes will not be able to join")
+	}
+	certPEM, err := i.Runner.ReadFile(opt.CertPath)
+	if err != nil {
+		return errors.Wrap(err, "read certificate")
+	}
+	keyPEM, err := i.Runner.ReadFile(opt.KeyPath)
+	if err != nil {
+		return errors.Wrap(err, "read key")
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return errors.Wrap(err, "load key pair")
+	}
+	srv := &http.Server{
+		Addr:              opt.Addr,
+		Handler:           &joinHandler{in: i, secret: secret, controlPlaneSecret: controlPlaneSecret},
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS13,
+		},
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	slog.Info("Serving join params", "addr", opt.Addr, "fingerprint", pki.Fingerprint(cert.Leaf))
+	if err := srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "serve")
+	}
+	return nil
+}
+
+//go:embed ki-join.service
+var joinService string
+
+const joinServicePath = "/etc/systemd/system/ki-join.service"
+
+// JoinService installs and starts join server as systemd service.
+//
+// Skipped if join secret or certificate are not provisioned.
+func (i *Installer) JoinService(ctx context.Context) error {
+	for _, name := range []string{config.JoinSecretPath, config.JoinCertPath, config.JoinKeyPath} {
+		if !i.exists(name) {
+			slog.Warn("Join server is not provisioned, nodes will not be able to join", "missing", name)
+			return nil
+		}
+	}
+	changed, err := i.writeFile(joinServicePath, []byte(joinService), 0600)
+	if err != nil {
+		return errors.Wrap(err, "write ki-join.service")
+	}
+	if changed {
+		if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"daemon-reload"}}); err != nil {
+			return errors.Wrap(err, "daemon-reload")
+		}
+	}
+	if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"enable", "--now", "ki-join.service"}}); err != nil {
+		return errors.Wrap(err, "enable ki-join.service")
+	}
+	return nil
+}
+
+type fetchJoinParamsOptions struct {
+	// Addr of join server, like 10.0.1.1:9444.
+	Addr string
+	// Fingerprint pins join server certificate public key.
+	Fingerprint  string
+	Secret       string
+	ControlPlane bool
+}
+
+// errFingerprintMismatch means that join server is not the one from ki.yaml.
+var errFingerprintMismatch = errors.New("certificate fingerprint mismatch")
+
+// joinClient returns client that trusts only certificate with public key
+// matching fingerprint.
+//
+// Join server certificate is self-signed, so pinning replaces chain and
+// host name verification.
+func joinClient(fingerprint string) *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				MinVersion:         tls.VersionTLS13,
+				InsecureSkipVerify: true, // #nosec G402: verified by pin below.
+				VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+					if len(rawCerts) == 0 {
+						return errors.New("no certificate")
+					}
+					cert, err := x509.ParseCertificate(rawCerts[0])
+					if err != nil {
+						return errors.Wrap(err, "parse certificate")
+					}
+					if got := pki.Fingerprint(cert); got != fingerprint {
+						return errors.Wrapf(errFingerprintMismatch, "got %s, expected %s", got, fingerprint)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// fetchJoinParams gets join parameters with fresh token from join server.
+func fetchJoinParams(ctx context.Context, opt fetchJoinParamsOptions) (InitParams, error) {
+	var params InitParams
+	body, err := json.Marshal(joinRequest{ControlPlane: opt.ControlPlane})
+	if err != nil {
+		return params, errors.Wrap(err, "marshal")
+	}
+	client := joinClient(opt.Fingerprint)
+	defer client.CloseIdleConnections()
+	u := "https://" + opt.Addr + joinPath
+	if err := retry(ctx, "fetch join params", func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return permanent(errors.Wrap(err, "create request"))
+		}
+		req.Header.Set("Authorization", "Bearer "+opt.Secret)
+		req.Header.Set("Content-Type", "application/json")
+		res, err := client.Do(req)
+		if errors.Is(err, errFingerprintMismatch) {
+			return permanent(err)
+		}
+		if err != nil {
+			return errors.Wrap(err, "post")
+		}
+		defer func() {
+			_ = res.Body.Close()
+		}()
+		if res.StatusCode != http.StatusOK {
+			return statusError(res)
+		}
+		if err := json.NewDecoder(res.Body).Decode(&params); err != nil {
+			return errors.Wrap(err, "decode")
+		}
+		return nil
+	}); err != nil {
+		return params, err
+	}
+	return params, nil
+}
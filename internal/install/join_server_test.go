@@ -0,0 +1,55 @@
+package install
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJoinHandlerAuth(t *testing.T) {
+	// Cluster is not initialized, so authorized requests get 503.
+	h := &joinHandler{
+		in:                 New(newRecordingRunner()),
+		secret:             "worker",
+		controlPlaneSecret: "control-plane",
+	}
+	for _, tt := range []struct {
+		Name         string
+		Secret       string
+		ControlPlane bool
+		Status       int
+	}{
+		{Name: "NoSecret", Status: http.StatusUnauthorized},
+		{Name: "BadSecret", Secret: "bad", Status: http.StatusUnauthorized},
+		{Name: "Worker", Secret: "worker", Status: http.StatusServiceUnavailable},
+		{Name: "WorkerAsControlPlane", Secret: "worker", ControlPlane: true, Status: http.StatusForbidden},
+		{Name: "ControlPlane", Secret: "control-plane", ControlPlane: true, Status: http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := `{"controlPlane":false}`
+			if tt.ControlPlane {
+				body = `{"controlPlane":true}`
+			}
+			req := httptest.NewRequest(http.MethodPost, joinPath, strings.NewReader(body))
+			if tt.Secret != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.Secret)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.Status {
+				t.Errorf("got %d, expected %d", w.Code, tt.Status)
+			}
+		})
+	}
+
+	// Control plane join is disabled without control plane secret.
+	h = &joinHandler{in: New(newRecordingRunner()), secret: "worker"}
+	req := httptest.NewRequest(http.MethodPost, joinPath, strings.NewReader(`{"controlPlane":true}`))
+	req.Header.Set("Authorization", "Bearer worker")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("control plane join without control plane secret: got %d, expected %d", w.Code, http.StatusForbidden)
+	}
+}
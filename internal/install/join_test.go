@@ -0,0 +1,51 @@
+package install
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBootstrapTokenActive(t *testing.T) {
+	var ready bool
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != clusterInfoPath {
+			http.NotFound(w, r)
+			return
+		}
+		if !ready {
+			// API server is up, but kubeadm init is not completed.
+			ready = true
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"kubeconfig":"...","jws-kubeconfig-abcdef":"signature"}}`))
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "https://")
+
+	ctx := WithRetryPolicy(context.Background(), RetryPolicy{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		MaxElapsedTime:  preGeneratedDiscoveryTimeout,
+	})
+	in := New(NewExecRunner())
+	for _, tt := range []struct {
+		Token  string
+		Active bool
+	}{
+		{Token: "abcdef.0123456789abcdef", Active: true},
+		{Token: "fedcba.0123456789abcdef", Active: false},
+	} {
+		active, err := in.bootstrapTokenActive(ctx, addr, tt.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active != tt.Active {
+			t.Errorf("%s: got %v, expected %v", tt.Token, active, tt.Active)
+		}
+	}
+}
@@ -1,48 +1,51 @@
 package install
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"os/exec"
+	"log/slog"
 	"path/filepath"
 	"sort"
 
 	"github.com/go-faster/errors"
 )
 
-func LoadKernelModules(name string, modules ...string) error {
+func modulesLoadPath(name string) string {
+	return filepath.Join("/etc/modules-load.d", name+".conf")
+}
+
+func sysctlPath(name string) string {
+	return filepath.Join("/etc/sysctl.d", name+".conf")
+}
+
+func (i *Installer) LoadKernelModules(ctx context.Context, name string, modules ...string) error {
 	for _, module := range modules {
-		fmt.Println("> Loading module", module)
-		cmd := exec.Command("modprobe", module)
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
-		if err := cmd.Run(); err != nil {
+		slog.Info("Loading module", "module", module)
+		if err := i.Runner.Run(ctx, Cmd{Name: "modprobe", Args: []string{module}}); err != nil {
 			return errors.Wrapf(err, "modprobe %s", module)
 		}
 	}
 	// Persist in named configuration.
-	fileName := filepath.Join("/etc/modules-load.d", name+".conf")
-	fmt.Printf("> Writing %s\n", fileName)
+	fileName := modulesLoadPath(name)
 	var out []byte
 	for _, module := range modules {
 		out = append(out, module...)
 		out = append(out, '\n')
 	}
-	if err := os.WriteFile(fileName, out, 0600); err != nil {
+	if _, err := i.writeFile(fileName, out, 0600); err != nil {
 		return errors.Wrap(err, "write")
 	}
 	return nil
 }
 
-func ConfigureKernelParameters(name string, params map[string]any) error {
-	fmt.Printf("> Configuring kernel parameters for %s\n", name)
-	fileName := filepath.Join("/etc/sysctl.d", name+".conf")
+func (i *Installer) ConfigureKernelParameters(ctx context.Context, name string, params map[string]any) error {
+	slog.Info("Configuring kernel parameters", "name", name)
+	fileName := sysctlPath(name)
 	keys := make([]string, 0, len(params))
 	for key := range params {
 		keys = append(keys, key)
 	}
 	sort.Strings(keys)
-	fmt.Printf("> Writing %s\n", fileName)
 	var out []byte
 	for _, key := range keys {
 		out = append(out, key...)
@@ -50,14 +53,11 @@ func ConfigureKernelParameters(name string, params map[string]any) error {
 		out = append(out, fmt.Sprintf("%v", params[key])...)
 		out = append(out, '\n')
 	}
-	if err := os.WriteFile(fileName, out, 0600); err != nil {
+	if _, err := i.writeFile(fileName, out, 0600); err != nil {
 		return errors.Wrap(err, "write")
 	}
-	// Reload.
-	cmd := exec.Command("sysctl", "--system")
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+	// Reload, parameters could be changed since last write.
+	if err := i.Runner.Run(ctx, Cmd{Name: "sysctl", Args: []string{"--system"}}); err != nil {
 		return errors.Wrap(err, "sysctl --system")
 	}
 	return nil
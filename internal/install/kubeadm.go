@@ -1,156 +1,409 @@
 package install
 
 import (
-	"bufio"
-	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
-	"io"
+	"log/slog"
 	"net"
 	"os"
-	"os/exec"
-	"regexp"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/cenkalti/backoff/v4"
 	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
 )
 
 type KubeadmInitOptions struct {
+	ClusterName          string
 	SkipPhases           []string
 	PodNetworkCIDR       string
 	ServiceCIDR          string
 	ControlPlaneEndpoint string
 	ExtraSans            []string
+	// KubernetesVersion is set from installed kubeadm, if empty.
+	KubernetesVersion string
+	// UploadCerts uploads control plane certificates to cluster,
+	// so other control plane nodes can join.
+	UploadCerts bool
+	// CertificateKey encrypts uploaded certificates, generated if empty.
+	CertificateKey string
+	Overrides      KubeadmOverrides
+	// BootstrapTokenPath is path to pre-generated bootstrap token, that
+	// is added to cluster if file exists.
+	BootstrapTokenPath string
+	// BootstrapTokenTTL is lifetime of pre-generated token, zero for
+	// token that never expires.
+	BootstrapTokenTTL time.Duration
+	// CACertPath and CAKeyPath are paths to pre-generated cluster CA,
+	// used instead of CA generated by kubeadm if files exist.
+	CACertPath string
+	CAKeyPath  string
+	// ExternalCloudProvider starts kubelet with --cloud-provider=external,
+	// so node is initialized by cloud controller manager.
+	ExternalCloudProvider bool
+	// Node configures kubelet node IP, provider ID and labels, optional.
+	Node *NodeIdentity
+	// AdvertiseAddress is address API server listens on, private IP of node.
+	AdvertiseAddress string
+	// bootstrapToken is read from BootstrapTokenPath.
+	bootstrapToken string
 }
 
 type InitParams struct {
 	Endpoint string `json:"endpoint"` // 1.2.3.4:6443
 	Token    string `json:"token"`
-	Hash     string `json:"hash"`
+	// Hash is SHA-256 hash of cluster CA public key for discovery.
+	Hash string `json:"hash"`
+	// CertificateKey decrypts control plane certificates, set only
+	// if cluster is initialized with multiple control plane nodes.
+	CertificateKey string `json:"certificateKey,omitempty"`
+
+	// Cluster identity, so joining node can check what it joins.
+	// Empty in params saved by older versions.
+	ClusterName       string `json:"clusterName,omitempty"`
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"` // v1.31.5
+	// CAFingerprint is SHA-256 hash of cluster CA certificate.
+	CAFingerprint string `json:"caFingerprint,omitempty"`
+}
+
+// newCertificateKey generates key for kubeadm --upload-certs,
+// same as "kubeadm certs certificate-key".
+func newCertificateKey() (string, error) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return "", errors.Wrap(err, "read random")
+	}
+	return hex.EncodeToString(key[:]), nil
+}
+
+// uploadCertsCmd returns command that uploads control plane certificates,
+// encrypted with key. Uploaded certificates are deleted after two hours.
+func uploadCertsCmd(key string) Cmd {
+	return Cmd{
+		Name:   "kubeadm",
+		Args:   []string{"init", "phase", "upload-certs", "--upload-certs", "--certificate-key", key},
+		Redact: true,
+	}
 }
 
-const initParamsPath = "/etc/kubeadm-init.json"
+const (
+	initParamsPath = "/etc/kubeadm-init.json"
+	// adminKubeconfig is created by kubeadm init on control plane node.
+	adminKubeconfig = "/etc/kubernetes/admin.conf"
+	// kubeletKubeconfig is created by both kubeadm init and kubeadm join.
+	kubeletKubeconfig = "/etc/kubernetes/kubelet.conf"
 
-func KubeadmInit(opts KubeadmInitOptions) error {
-	var args []string
-	if len(opts.SkipPhases) > 0 {
-		args = append(args, "--skip-phases="+strings.Join(opts.SkipPhases, ","))
+	// kubeadm uses existing CA from certificates directory.
+	kubeadmCACertPath = "/etc/kubernetes/pki/ca.crt"
+	kubeadmCAKeyPath  = "/etc/kubernetes/pki/ca.key"
+)
+
+// installCA copies pre-generated cluster CA to kubeadm certificates
+// directory, reporting whether it was installed.
+func (i *Installer) installCA(certPath, keyPath string) (bool, error) {
+	if certPath == "" || keyPath == "" || !i.exists(certPath) || !i.exists(keyPath) {
+		return false, nil
 	}
-	if opts.PodNetworkCIDR != "" {
-		args = append(args, "--pod-network-cidr="+opts.PodNetworkCIDR)
+	if err := i.Runner.MkdirAll(filepath.Dir(kubeadmCACertPath), 0755); err != nil {
+		return false, errors.Wrap(err, "mkdir")
 	}
-	if opts.ServiceCIDR != "" {
-		args = append(args, "--service-cidr="+opts.ServiceCIDR)
+	for _, f := range []struct {
+		From, To string
+		Perm     os.FileMode
+	}{
+		{From: certPath, To: kubeadmCACertPath, Perm: 0644},
+		{From: keyPath, To: kubeadmCAKeyPath, Perm: 0600},
+	} {
+		data, err := i.Runner.ReadFile(f.From)
+		if err != nil {
+			return false, errors.Wrap(err, "read")
+		}
+		if _, err := i.writeFile(f.To, data, f.Perm); err != nil {
+			return false, errors.Wrap(err, "write")
+		}
 	}
-	if opts.ControlPlaneEndpoint != "" {
-		args = append(args, "--control-plane-endpoint="+opts.ControlPlaneEndpoint)
+	return true, nil
+}
+
+func (i *Installer) exists(name string) bool {
+	_, err := i.Runner.Stat(name)
+	return err == nil
+}
+
+func (i *Installer) KubeadmInit(ctx context.Context, opts KubeadmInitOptions) error {
+	if i.exists(initParamsPath) {
+		slog.Info("Cluster is already initialized")
+		return nil
 	}
-	for _, san := range opts.ExtraSans {
-		args = append(args, "--apiserver-cert-extra-sans="+san)
+	if opts.UploadCerts && opts.CertificateKey == "" {
+		key, err := newCertificateKey()
+		if err != nil {
+			return errors.Wrap(err, "generate certificate key")
+		}
+		opts.CertificateKey = key
 	}
-	fmt.Println("> kubeadm init", args)
-	cmd := exec.Command("kubeadm", append([]string{"init"}, args...)...)
-	output := bytes.NewBuffer(nil)
-	cmd.Stderr = io.MultiWriter(os.Stderr, output)
-	cmd.Stdout = io.MultiWriter(os.Stdout, output)
-	if err := cmd.Run(); err != nil {
-		return errors.Wrap(err, "kubeadm init")
+	if opts.KubernetesVersion == "" {
+		v, err := i.kubeadmVersion(ctx)
+		if err != nil {
+			return errors.Wrap(err, "get kubernetes version")
+		}
+		opts.KubernetesVersion = v
 	}
-	reToken := regexp.MustCompile(`--token (\S+)`)
-	reHash := regexp.MustCompile(`--discovery-token-ca-cert-hash (\S+)`)
-	var token, hash string
-	scanner := bufio.NewScanner(output)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if m := reToken.FindStringSubmatch(line); m != nil {
-			token = m[1]
+	if i.exists(adminKubeconfig) {
+		// Initialized, but init params were not saved, e.g. ki was
+		// interrupted right after kubeadm init.
+		slog.Info("Cluster is already initialized")
+		if opts.UploadCerts {
+			slog.Info("Uploading certificates")
+			if err := i.Runner.Run(ctx, uploadCertsCmd(opts.CertificateKey)); err != nil {
+				return errors.Wrap(err, "upload certs")
+			}
+		}
+	} else {
+		installed, err := i.installCA(opts.CACertPath, opts.CAKeyPath)
+		if err != nil {
+			return errors.Wrap(err, "install CA")
+		}
+		if installed {
+			slog.Info("Using pre-generated cluster CA", "path", opts.CACertPath)
+		}
+		if opts.BootstrapTokenPath != "" && i.exists(opts.BootstrapTokenPath) {
+			token, err := i.readSecret(opts.BootstrapTokenPath)
+			if err != nil {
+				return errors.Wrap(err, "bootstrap token")
+			}
+			opts.bootstrapToken = token
 		}
-		if m := reHash.FindStringSubmatch(line); m != nil {
-			hash = m[1]
+		cfg, err := RenderKubeadmInitConfig(opts)
+		if err != nil {
+			return errors.Wrap(err, "render config")
 		}
+		if err := i.writeKubeadmConfig(kubeadmInitConfigPath, cfg); err != nil {
+			return errors.Wrap(err, "write config")
+		}
+		args := []string{"init", "--config", kubeadmInitConfigPath}
+		if opts.UploadCerts {
+			args = append(args, "--upload-certs")
+		}
+		slog.Info("kubeadm init", "args", args)
+		if err := i.Runner.Run(ctx, Cmd{
+			Name: "kubeadm",
+			Args: args,
+		}); err != nil {
+			return errors.Wrap(err, "kubeadm init")
+		}
+	}
+	if opts.CAKeyPath != "" && i.exists(opts.CAKeyPath) {
+		// Key is kept in kubeadm certificates directory, removed only
+		// after init so that retried init uses same CA.
+		if err := i.Runner.RemoveAll(opts.CAKeyPath); err != nil {
+			return errors.Wrap(err, "remove CA key")
+		}
+	}
+	// Token is saved for compatibility, joining nodes get fresh one, see JoinParams.
+	token, err := i.createJoinToken(ctx)
+	if err != nil {
+		return errors.Wrap(err, "create join token")
 	}
-	if token == "" || hash == "" {
-		return errors.New("token or hash not found")
+	hash, fingerprint, err := i.clusterCA()
+	if err != nil {
+		return errors.Wrap(err, "cluster CA")
 	}
-	fmt.Printf("> Token: %s\nHash: %s\n", token, hash)
+	slog.Info("Got join parameters", "hash", hash, "ca_fingerprint", fingerprint)
 
 	data, err := json.Marshal(InitParams{
-		Endpoint: net.JoinHostPort(opts.ControlPlaneEndpoint, "6443"),
-		Token:    token,
-		Hash:     hash,
+		Endpoint:          net.JoinHostPort(opts.ControlPlaneEndpoint, "6443"),
+		Token:             token,
+		Hash:              hash,
+		CertificateKey:    opts.CertificateKey,
+		ClusterName:       opts.ClusterName,
+		KubernetesVersion: opts.KubernetesVersion,
+		CAFingerprint:     fingerprint,
 	})
 	if err != nil {
 		return errors.Wrap(err, "marshal")
 	}
-	if err := os.WriteFile(initParamsPath, data, 0600); err != nil {
+	if err := i.Runner.WriteFile(initParamsPath, data, 0600); err != nil {
 		return errors.Wrap(err, "write")
 	}
 
 	return nil
 }
 
-func KubeadmJoin(controlPlaneNodeInternalIP string) error {
+func waitControlPlane(ctx context.Context, controlPlaneNodeInternalIP string) error {
 	// Wait for 6443 port on control plane node.
-	{
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-		defer cancel()
-		ticker := time.NewTicker(time.Second)
-		fmt.Println("> Waiting for control plane node")
-		defer ticker.Stop()
-		for range ticker.C {
-			if ctx.Err() != nil {
-				return errors.New("timeout waiting for control plane to listen on 6443")
-			}
-			err := func() error {
-				conn, err := net.Dial("tcp", net.JoinHostPort(controlPlaneNodeInternalIP, "6443"))
-				if err != nil {
-					fmt.Println(err)
-					return err
-				}
-				_ = conn.Close()
-				return nil
-			}()
-			if err == nil {
-				break
-			}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	slog.Info("Waiting for control plane node", "ip", controlPlaneNodeInternalIP)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "wait for control plane to listen on 6443")
 		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(controlPlaneNodeInternalIP, "6443"))
+		if err != nil {
+			slog.Info("Control plane is not available yet", "error", err)
+			continue
+		}
+		_ = conn.Close()
+		break
 	}
+	return nil
+}
 
-	fmt.Println("> kubeadm join")
+type KubeadmJoinOptions struct {
+	ControlPlaneInternalIP string
+	// ControlPlane joins node as control plane instead of worker.
+	ControlPlane bool
+	Overrides    KubeadmOverrides
+	// JoinServer is address of join server on first control plane node.
+	JoinServer string
+	// JoinFingerprint pins join server certificate.
+	JoinFingerprint string
+	// JoinSecretPath is path to pre-shared join secret.
+	JoinSecretPath string
+	// CACertHash is hash of pre-generated cluster CA. Workers join with
+	// token from BootstrapTokenPath without join server, if both are set.
+	CACertHash         string
+	BootstrapTokenPath string
+	// Endpoint is API server address for join with pre-generated token.
+	Endpoint string
+	// DiscoveryTimeout overrides kubeadm discovery timeout of 5 minutes.
+	DiscoveryTimeout time.Duration
+	// ClusterName and KubernetesVersion (like v1.31) are expected from
+	// join server, checked if set.
+	ClusterName       string
+	KubernetesVersion string
+	// ExternalCloudProvider starts kubelet with --cloud-provider=external.
+	ExternalCloudProvider bool
+	Node                  *NodeIdentity
+	// AdvertiseAddress is address API server of control plane node listens on.
+	AdvertiseAddress string
+}
 
-	var params InitParams
-	{
-		bo := backoff.NewConstantBackOff(time.Second)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-		defer cancel()
-		if err := backoff.RetryNotify(func() error {
-			cmd := exec.Command("ssh", "-o", "StrictHostKeyChecking=accept-new", "cluster@"+controlPlaneNodeInternalIP, "sudo", "cat", initParamsPath)
-			output, err := cmd.Output()
-			if err != nil {
-				return errors.Wrap(err, "ssh")
-			}
-			if err := json.Unmarshal(output, &params); err != nil {
-				return errors.Wrap(err, "unmarshal")
-			}
-			return nil
-		}, backoff.WithContext(bo, ctx), func(err error, d time.Duration) {}); err != nil {
-			return errors.Wrap(err, "retrieve config")
+// checkJoinParams checks that join params from join server are for
+// expected cluster.
+func checkJoinParams(params InitParams, opts KubeadmJoinOptions) error {
+	if opts.CACertHash != "" && params.Hash != opts.CACertHash {
+		return errors.Errorf("cluster CA hash %s does not match expected %s", params.Hash, opts.CACertHash)
+	}
+	// Params saved by older versions have no cluster identity.
+	if params.ClusterName != "" && opts.ClusterName != "" && params.ClusterName != opts.ClusterName {
+		return errors.Errorf("cluster name %q does not match expected %q", params.ClusterName, opts.ClusterName)
+	}
+	if params.KubernetesVersion != "" && opts.KubernetesVersion != "" {
+		cluster, clusterOK := config.MinorVersion(config.MajorMinor(params.KubernetesVersion))
+		node, nodeOK := config.MinorVersion(config.MajorMinor(opts.KubernetesVersion))
+		// Older kubelet is supported, but kubeadm can't join older cluster.
+		if clusterOK && nodeOK && node > cluster {
+			return errors.Errorf("node version %s is newer than cluster version %s", opts.KubernetesVersion, params.KubernetesVersion)
 		}
 	}
+	return nil
+}
+
+// preGeneratedDiscoveryTimeout covers install of control plane node, that
+// is created along with workers. Kubeadm retries discovery until timeout.
+const preGeneratedDiscoveryTimeout = 15 * time.Minute
+
+func (i *Installer) KubeadmJoin(ctx context.Context, opts KubeadmJoinOptions) error {
+	controlPlaneNodeInternalIP := opts.ControlPlaneInternalIP
+	if i.exists(kubeletKubeconfig) {
+		slog.Info("Node has already joined cluster")
+		return nil
+	}
+	params := InitParams{
+		Endpoint: net.JoinHostPort(controlPlaneNodeInternalIP, "6443"),
+		Token:    "<token>",
+		Hash:     "<hash>",
+		// Placeholder is 32 bytes, as expected by kubeadm.
+		CertificateKey: strings.Repeat("00", 32),
+	}
+	var token string
+	if !opts.ControlPlane && opts.CACertHash != "" && opts.BootstrapTokenPath != "" && i.exists(opts.BootstrapTokenPath) {
+		// Control plane nodes still use join server for certificate key.
+		t, err := i.readSecret(opts.BootstrapTokenPath)
+		if err != nil {
+			return errors.Wrap(err, "bootstrap token")
+		}
+		active, err := i.bootstrapTokenActive(ctx, net.JoinHostPort(opts.Endpoint, "6443"), t)
+		if err != nil {
+			return errors.Wrap(err, "check bootstrap token")
+		}
+		if active {
+			token = t
+		} else {
+			// Node is created after join.tokenTTL.
+			slog.Warn("Pre-generated bootstrap token has expired, using join server")
+		}
+	}
+	switch {
+	case token != "":
+		slog.Info("Using pre-generated bootstrap token")
+		params = InitParams{
+			Endpoint: net.JoinHostPort(opts.Endpoint, "6443"),
+			Token:    token,
+			Hash:     opts.CACertHash,
+		}
+		opts.DiscoveryTimeout = preGeneratedDiscoveryTimeout
+	case !i.dryRun():
+		if err := waitControlPlane(ctx, controlPlaneNodeInternalIP); err != nil {
+			return errors.Wrap(err, "wait control plane")
+		}
+		if opts.JoinFingerprint == "" {
+			return errors.New("join server fingerprint is not set")
+		}
+		secret, err := i.readSecret(opts.JoinSecretPath)
+		if err != nil {
+			return errors.Wrap(err, "join secret")
+		}
+		slog.Info("Fetching join parameters", "server", opts.JoinServer)
+		p, err := fetchJoinParams(ctx, fetchJoinParamsOptions{
+			Addr:         opts.JoinServer,
+			Fingerprint:  opts.JoinFingerprint,
+			Secret:       secret,
+			ControlPlane: opts.ControlPlane,
+		})
+		if err != nil {
+			return errors.Wrap(err, "fetch join params")
+		}
+		if err := checkJoinParams(p, opts); err != nil {
+			return errors.Wrap(err, "check join params")
+		}
+		params = p
+	}
+	if opts.ControlPlane && params.CertificateKey == "" {
+		return errors.New("no certificate key for control plane join")
+	}
 	if params.Hash == "" || params.Token == "" || params.Endpoint == "" {
-		return errors.Errorf("invalid params from %s", initParamsPath)
+		return errors.New("invalid join params")
+	}
+	cfg, err := RenderKubeadmJoinConfig(params, opts)
+	if err != nil {
+		return errors.Wrap(err, "render config")
 	}
-	fmt.Printf("Got params: %+v\n", params)
-	arg := []string{
-		"join", params.Endpoint, "--token", params.Token, "--discovery-token-ca-cert-hash", params.Hash,
+	if err := i.writeKubeadmConfig(kubeadmJoinConfigPath, cfg); err != nil {
+		return errors.Wrap(err, "write config")
 	}
-	cmd := exec.Command("kubeadm", arg...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+	slog.Info("kubeadm join",
+		"endpoint", params.Endpoint,
+		"hash", params.Hash,
+		"ca_fingerprint", params.CAFingerprint,
+		"cluster", params.ClusterName,
+		"version", params.KubernetesVersion,
+		"control_plane", opts.ControlPlane,
+		"config", kubeadmJoinConfigPath,
+	)
+	if err := i.Runner.Run(ctx, Cmd{
+		Name: "kubeadm",
+		Args: []string{"join", "--config", kubeadmJoinConfigPath},
+	}); err != nil {
 		return errors.Wrap(err, "kubeadm join")
 	}
 
@@ -0,0 +1,239 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/go-faster/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	kubeadmAPIVersion = "kubeadm.k8s.io/v1beta4"
+	kubeletAPIVersion = "kubelet.config.k8s.io/v1beta1"
+
+	// Rendered kubeadm configs are kept for auditing.
+	kubeadmInitConfigPath = "/etc/ki/kubeadm-init.yaml"
+	kubeadmJoinConfigPath = "/etc/ki/kubeadm-join.yaml"
+)
+
+// KubeadmOverrides are merged over rendered kubeadm documents, so any
+// field of kubeadm v1beta4 and kubelet v1beta1 configuration can be set.
+type KubeadmOverrides struct {
+	InitConfiguration    map[string]any
+	ClusterConfiguration map[string]any
+	KubeletConfiguration map[string]any
+	JoinConfiguration    map[string]any
+}
+
+// mergeValues recursively merges src into dst, values from src win.
+//
+// Nested maps are merged, other values (including lists) are replaced.
+func mergeValues(dst, src map[string]any) map[string]any {
+	if dst == nil {
+		dst = map[string]any{}
+	}
+	for k, v := range src {
+		srcMap, srcOK := v.(map[string]any)
+		dstMap, dstOK := dst[k].(map[string]any)
+		if srcOK && dstOK {
+			dst[k] = mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// marshalDocuments encodes documents as multi-document YAML.
+func marshalDocuments(docs ...map[string]any) ([]byte, error) {
+	var b bytes.Buffer
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	for _, doc := range docs {
+		if err := e.Encode(doc); err != nil {
+			return nil, errors.Wrapf(err, "encode %v", doc["kind"])
+		}
+	}
+	if err := e.Close(); err != nil {
+		return nil, errors.Wrap(err, "close")
+	}
+	return b.Bytes(), nil
+}
+
+// nodeRegistration returns nodeRegistration of InitConfiguration or
+// JoinConfiguration, nil if defaults are used.
+func nodeRegistration(externalCloudProvider bool, node *NodeIdentity) map[string]any {
+	var args []map[string]any
+	arg := func(name, value string) {
+		args = append(args, map[string]any{"name": name, "value": value})
+	}
+	if externalCloudProvider {
+		arg("cloud-provider", "external")
+	}
+	if node != nil {
+		if node.IP != "" {
+			arg("node-ip", node.IP)
+		}
+		if node.ProviderID != "" {
+			arg("provider-id", node.ProviderID)
+		}
+		if len(node.Labels) > 0 {
+			labels := make([]string, 0, len(node.Labels))
+			for k, v := range node.Labels {
+				labels = append(labels, k+"="+v)
+			}
+			sort.Strings(labels)
+			arg("node-labels", strings.Join(labels, ","))
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return map[string]any{"kubeletExtraArgs": args}
+}
+
+// RenderKubeadmInitConfig renders InitConfiguration, ClusterConfiguration
+// and KubeletConfiguration for kubeadm init.
+func RenderKubeadmInitConfig(opts KubeadmInitOptions) ([]byte, error) {
+	initCfg := map[string]any{
+		"apiVersion": kubeadmAPIVersion,
+		"kind":       "InitConfiguration",
+	}
+	if len(opts.SkipPhases) > 0 {
+		initCfg["skipPhases"] = opts.SkipPhases
+	}
+	if opts.CertificateKey != "" {
+		initCfg["certificateKey"] = opts.CertificateKey
+	}
+	if opts.AdvertiseAddress != "" {
+		initCfg["localAPIEndpoint"] = map[string]any{
+			"advertiseAddress": opts.AdvertiseAddress,
+		}
+	}
+	// Short-lived token instead of default one, valid for 24 hours.
+	// Joining nodes get their own tokens.
+	tokens := []map[string]any{
+		{
+			"description": joinTokenDescription,
+			"ttl":         joinTokenTTL.String(),
+		},
+	}
+	if opts.bootstrapToken != "" {
+		tokens = append(tokens, map[string]any{
+			"token":       opts.bootstrapToken,
+			"description": bootstrapTokenDescription,
+			"ttl":         opts.BootstrapTokenTTL.String(),
+		})
+	}
+	initCfg["bootstrapTokens"] = tokens
+	if r := nodeRegistration(opts.ExternalCloudProvider, opts.Node); r != nil {
+		initCfg["nodeRegistration"] = r
+	}
+
+	networking := map[string]any{}
+	if opts.PodNetworkCIDR != "" {
+		networking["podSubnet"] = opts.PodNetworkCIDR
+	}
+	if opts.ServiceCIDR != "" {
+		networking["serviceSubnet"] = opts.ServiceCIDR
+	}
+	clusterCfg := map[string]any{
+		"apiVersion": kubeadmAPIVersion,
+		"kind":       "ClusterConfiguration",
+		"networking": networking,
+	}
+	if opts.ClusterName != "" {
+		clusterCfg["clusterName"] = opts.ClusterName
+	}
+	if opts.KubernetesVersion != "" {
+		clusterCfg["kubernetesVersion"] = opts.KubernetesVersion
+	}
+	if opts.ControlPlaneEndpoint != "" {
+		clusterCfg["controlPlaneEndpoint"] = net.JoinHostPort(opts.ControlPlaneEndpoint, "6443")
+	}
+	if len(opts.ExtraSans) > 0 {
+		clusterCfg["apiServer"] = map[string]any{
+			"certSANs": opts.ExtraSans,
+		}
+	}
+
+	kubeletCfg := map[string]any{
+		"apiVersion": kubeletAPIVersion,
+		"kind":       "KubeletConfiguration",
+		// Same as SystemdCgroup in containerd config.
+		"cgroupDriver": "systemd",
+	}
+
+	o := opts.Overrides
+	return marshalDocuments(
+		mergeValues(initCfg, o.InitConfiguration),
+		mergeValues(clusterCfg, o.ClusterConfiguration),
+		mergeValues(kubeletCfg, o.KubeletConfiguration),
+	)
+}
+
+// RenderKubeadmJoinConfig renders JoinConfiguration for kubeadm join.
+//
+// Kubelet configuration of joining node is downloaded from cluster.
+func RenderKubeadmJoinConfig(params InitParams, opts KubeadmJoinOptions) ([]byte, error) {
+	joinCfg := map[string]any{
+		"apiVersion": kubeadmAPIVersion,
+		"kind":       "JoinConfiguration",
+		"discovery": map[string]any{
+			"bootstrapToken": map[string]any{
+				"apiServerEndpoint": params.Endpoint,
+				"token":             params.Token,
+				"caCertHashes":      []string{params.Hash},
+			},
+		},
+	}
+	if r := nodeRegistration(opts.ExternalCloudProvider, opts.Node); r != nil {
+		joinCfg["nodeRegistration"] = r
+	}
+	if opts.DiscoveryTimeout > 0 {
+		joinCfg["timeouts"] = map[string]any{
+			"discovery": opts.DiscoveryTimeout.String(),
+		}
+	}
+	if opts.ControlPlane {
+		controlPlane := map[string]any{
+			"certificateKey": params.CertificateKey,
+		}
+		if opts.AdvertiseAddress != "" {
+			controlPlane["localAPIEndpoint"] = map[string]any{
+				"advertiseAddress": opts.AdvertiseAddress,
+			}
+		}
+		joinCfg["controlPlane"] = controlPlane
+	}
+	return marshalDocuments(mergeValues(joinCfg, opts.Overrides.JoinConfiguration))
+}
+
+// kubeadmVersion returns version of installed kubeadm, like v1.31.5.
+//
+// Empty version is returned in dry run if kubeadm is not installed yet.
+func (i *Installer) kubeadmVersion(ctx context.Context) (string, error) {
+	out, err := i.Runner.Output(ctx, Cmd{Name: "kubeadm", Args: []string{"version", "-o", "short"}})
+	if err != nil {
+		return "", errors.Wrap(err, "kubeadm version")
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// writeKubeadmConfig writes rendered config for kubeadm --config.
+func (i *Installer) writeKubeadmConfig(name string, data []byte) error {
+	slog.Info("Writing kubeadm config", "path", name)
+	if err := i.Runner.MkdirAll("/etc/ki", 0750); err != nil {
+		return errors.Wrap(err, "mkdir")
+	}
+	// Join config contains bootstrap token.
+	if _, err := i.writeFile(name, data, 0600); err != nil {
+		return errors.Wrap(err, "write")
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package install
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// golden compares data with testdata/<name>.golden, updating it with -update.
+func golden(t *testing.T, name string, data []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("%s mismatch, run with -update if expected\ngot:\n%s\nexpected:\n%s", path, data, expected)
+	}
+}
+
+var testNode = &NodeIdentity{
+	IP:         "10.0.1.2",
+	ProviderID: "hcloud://42",
+	Labels: map[string]string{
+		"topology.kubernetes.io/zone":   "fsn1-dc14",
+		"topology.kubernetes.io/region": "fsn1",
+	},
+}
+
+func TestRenderKubeadmInitConfig(t *testing.T) {
+	for _, tt := range []struct {
+		Name string
+		Opts KubeadmInitOptions
+	}{
+		{
+			Name: "init-single",
+			Opts: KubeadmInitOptions{
+				ClusterName:          "ki",
+				SkipPhases:           []string{"addon/kube-proxy"},
+				PodNetworkCIDR:       "10.244.0.0/16",
+				ServiceCIDR:          "10.96.0.0/12",
+				ControlPlaneEndpoint: "10.0.1.1",
+				KubernetesVersion:    "v1.31.5",
+				AdvertiseAddress:     "10.0.1.1",
+				Overrides: KubeadmOverrides{
+					ClusterConfiguration: map[string]any{
+						"networking": map[string]any{
+							"dnsDomain": "cluster.example",
+						},
+					},
+					KubeletConfiguration: map[string]any{
+						"maxPods": 200,
+					},
+				},
+			},
+		},
+		{
+			Name: "init-ha",
+			Opts: KubeadmInitOptions{
+				ClusterName:           "ki",
+				SkipPhases:            []string{"addon/kube-proxy"},
+				PodNetworkCIDR:        "10.244.0.0/16",
+				ServiceCIDR:           "10.96.0.0/12",
+				ControlPlaneEndpoint:  "10.0.0.100",
+				ExtraSans:             []string{"10.0.0.100", "203.0.113.1"},
+				KubernetesVersion:     "v1.31.5",
+				UploadCerts:           true,
+				CertificateKey:        "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				BootstrapTokenTTL:     24 * time.Hour,
+				ExternalCloudProvider: true,
+				Node:                  testNode,
+				AdvertiseAddress:      "10.0.1.2",
+				bootstrapToken:        "abcdef.0123456789abcdef",
+				Overrides: KubeadmOverrides{
+					InitConfiguration: map[string]any{
+						"nodeRegistration": map[string]any{
+							"taints": []any{},
+						},
+					},
+					ClusterConfiguration: map[string]any{
+						"apiServer": map[string]any{
+							"extraArgs": []any{
+								map[string]any{"name": "audit-log-maxage", "value": "30"},
+							},
+						},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			data, err := RenderKubeadmInitConfig(tt.Opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, tt.Name, data)
+		})
+	}
+}
+
+func TestRenderKubeadmJoinConfig(t *testing.T) {
+	params := InitParams{
+		Endpoint:       "10.0.0.100:6443",
+		Token:          "abcdef.0123456789abcdef",
+		Hash:           "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		CertificateKey: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+	}
+	for _, tt := range []struct {
+		Name string
+		Opts KubeadmJoinOptions
+	}{
+		{
+			Name: "join-worker",
+			Opts: KubeadmJoinOptions{
+				DiscoveryTimeout:      preGeneratedDiscoveryTimeout,
+				ExternalCloudProvider: true,
+				Node:                  testNode,
+				Overrides: KubeadmOverrides{
+					JoinConfiguration: map[string]any{
+						"nodeRegistration": map[string]any{
+							"taints": []any{
+								map[string]any{"key": "dedicated", "value": "ingress", "effect": "NoSchedule"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "join-control-plane",
+			Opts: KubeadmJoinOptions{
+				ControlPlane:          true,
+				ExternalCloudProvider: true,
+				Node:                  testNode,
+				AdvertiseAddress:      "10.0.1.2",
+				Overrides: KubeadmOverrides{
+					JoinConfiguration: map[string]any{
+						"controlPlane": map[string]any{
+							"localAPIEndpoint": map[string]any{
+								"bindPort": 6443,
+							},
+						},
+						"skipPhases": []any{"control-plane-prepare/download-certs"},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			data, err := RenderKubeadmJoinConfig(params, tt.Opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, tt.Name, data)
+		})
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	for _, tt := range []struct {
+		Name     string
+		Dst      map[string]any
+		Src      map[string]any
+		Expected map[string]any
+	}{
+		{
+			Name:     "NilDst",
+			Src:      map[string]any{"a": 1},
+			Expected: map[string]any{"a": 1},
+		},
+		{
+			Name:     "NilSrc",
+			Dst:      map[string]any{"a": 1},
+			Expected: map[string]any{"a": 1},
+		},
+		{
+			Name:     "Scalar",
+			Dst:      map[string]any{"a": 1, "b": "x"},
+			Src:      map[string]any{"a": 2, "c": true},
+			Expected: map[string]any{"a": 2, "b": "x", "c": true},
+		},
+		{
+			Name: "NestedMap",
+			Dst: map[string]any{
+				"networking": map[string]any{"podSubnet": "10.244.0.0/16", "serviceSubnet": "10.96.0.0/12"},
+			},
+			Src: map[string]any{
+				"networking": map[string]any{"podSubnet": "10.42.0.0/16", "dnsDomain": "cluster.example"},
+			},
+			Expected: map[string]any{
+				"networking": map[string]any{
+					"podSubnet":     "10.42.0.0/16",
+					"serviceSubnet": "10.96.0.0/12",
+					"dnsDomain":     "cluster.example",
+				},
+			},
+		},
+		{
+			Name:     "ListReplaced",
+			Dst:      map[string]any{"certSANs": []string{"10.0.0.1", "10.0.0.2"}},
+			Src:      map[string]any{"certSANs": []any{"example.com"}},
+			Expected: map[string]any{"certSANs": []any{"example.com"}},
+		},
+		{
+			Name:     "EmptyListReplaced",
+			Dst:      map[string]any{"taints": []any{map[string]any{"key": "a"}}},
+			Src:      map[string]any{"taints": []any{}},
+			Expected: map[string]any{"taints": []any{}},
+		},
+		{
+			Name:     "MapReplacedByScalar",
+			Dst:      map[string]any{"apiServer": map[string]any{"certSANs": []string{"a"}}},
+			Src:      map[string]any{"apiServer": nil},
+			Expected: map[string]any{"apiServer": nil},
+		},
+		{
+			Name:     "ScalarReplacedByMap",
+			Dst:      map[string]any{"timeouts": "5m"},
+			Src:      map[string]any{"timeouts": map[string]any{"discovery": "15m"}},
+			Expected: map[string]any{"timeouts": map[string]any{"discovery": "15m"}},
+		},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			got := mergeValues(tt.Dst, tt.Src)
+			if !reflect.DeepEqual(got, tt.Expected) {
+				t.Errorf("got %v, expected %v", got, tt.Expected)
+			}
+		})
+	}
+}
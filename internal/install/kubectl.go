@@ -1,9 +1,9 @@
 package install
 
 import (
-	"fmt"
-	"os"
-	"os/exec"
+	"bytes"
+	"context"
+	"log/slog"
 
 	"github.com/go-faster/errors"
 )
@@ -13,15 +13,41 @@ type KubectlApplyOptions struct {
 	Kubeconfig string
 }
 
-func KubectlApply(opt KubectlApplyOptions) error {
-	fmt.Println("> kubectl apply -f", opt.File)
-	cmd := exec.Command("kubectl", "apply", "-f", opt.File)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+func (i *Installer) KubectlApply(ctx context.Context, opt KubectlApplyOptions) error {
+	slog.Info("kubectl apply", "file", opt.File)
+	cmd := Cmd{
+		Name: "kubectl",
+		Args: []string{"apply", "-f", opt.File},
+	}
 	if opt.Kubeconfig != "" {
-		cmd.Env = appendEnv(os.Environ(), "KUBECONFIG", opt.Kubeconfig)
+		cmd.Env = appendEnv(cmd.Env, "KUBECONFIG", opt.Kubeconfig)
+	}
+	// File is usually fetched by URL.
+	if err := retry(ctx, "kubectl apply", func() error {
+		return i.Runner.Run(ctx, cmd)
+	}); err != nil {
+		return errors.Wrap(err, "kubectl apply")
+	}
+	return nil
+}
+
+// KubectlCreateOrUpdate renders object with "kubectl create" arguments
+// and applies it, so object is updated if it already exists.
+func (i *Installer) KubectlCreateOrUpdate(ctx context.Context, args ...string) error {
+	manifest, err := i.Runner.Output(ctx, Cmd{
+		Name: "kubectl",
+		Args: append(append([]string{"create"}, args...), "--dry-run=client", "-o", "yaml"),
+		// Secret data is passed as literal arguments.
+		Redact: len(args) > 0 && args[0] == "secret",
+	})
+	if err != nil {
+		return errors.Wrap(err, "kubectl create")
 	}
-	if err := cmd.Run(); err != nil {
+	if err := i.Runner.Run(ctx, Cmd{
+		Name:  "kubectl",
+		Args:  []string{"apply", "-f", "-"},
+		Stdin: bytes.NewReader(manifest),
+	}); err != nil {
 		return errors.Wrap(err, "kubectl apply")
 	}
 	return nil
@@ -0,0 +1,79 @@
+package install
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/metadata"
+)
+
+// NodeIdentity is kubelet configuration of node, discovered from Hetzner
+// metadata service.
+type NodeIdentity struct {
+	// IP is address in private network, used as kubelet --node-ip.
+	IP string
+	// MACAddress is address of private network interface.
+	MACAddress string
+	// ProviderID is like hcloud://42.
+	ProviderID string
+	Labels     map[string]string
+	// Metadata is raw metadata of server.
+	Metadata *metadata.Metadata
+}
+
+type NodeIdentityOptions struct {
+	// MetadataURL is base URL of metadata service, discovery is disabled if empty.
+	MetadataURL string
+	// Network is name of private network.
+	Network string
+}
+
+// NodeIdentity queries metadata service for node identity, returning nil
+// if discovery is disabled.
+//
+// Metadata service is not available outside Hetzner Cloud, so in dry run
+// error is only logged.
+func (i *Installer) NodeIdentity(ctx context.Context, opt NodeIdentityOptions) (*NodeIdentity, error) {
+	if opt.MetadataURL == "" {
+		return nil, nil
+	}
+	client := &metadata.Client{
+		BaseURL:    opt.MetadataURL,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	var m *metadata.Metadata
+	get := func() (err error) {
+		m, err = client.Get(ctx)
+		return err
+	}
+	if i.dryRun() {
+		if err := get(); err != nil {
+			slog.Warn("Metadata service is not available", "error", err)
+			return nil, nil
+		}
+	} else if err := retry(ctx, "metadata", get); err != nil {
+		return nil, errors.Wrap(err, "get metadata")
+	}
+	network, ok := m.PrivateNetwork(opt.Network)
+	if !ok {
+		return nil, errors.Errorf("server is not attached to private network %q", opt.Network)
+	}
+	node := &NodeIdentity{
+		IP:         network.IP,
+		MACAddress: network.MACAddress,
+		ProviderID: m.ProviderID(),
+		Labels:     m.Labels(),
+		Metadata:   m,
+	}
+	slog.Info("Node identity",
+		"hostname", m.Hostname,
+		"ip", node.IP,
+		"provider_id", node.ProviderID,
+		"zone", m.AvailabilityZone,
+	)
+	return node, nil
+}
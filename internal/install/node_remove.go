@@ -0,0 +1,278 @@
+package install
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const (
+	// controlPlaneLabel marks control plane nodes.
+	controlPlaneLabel = "node-role.kubernetes.io/control-plane"
+	// serverRoleLabel is label of Hetzner server with role of node,
+	// "control-plane" or "worker", set by terraform.
+	serverRoleLabel = "role"
+)
+
+type RemoveNodeOptions struct {
+	// Name of node and Hetzner server.
+	Name string
+	// Kubeconfig with cluster admin access.
+	Kubeconfig string
+	// Reset runs "kubeadm reset" on node over SSH before deletion,
+	// required to remove etcd member of control plane node.
+	Reset bool
+	// SSHUser is user with passwordless sudo on node, used with Reset.
+	SSHUser string
+	// TokenPath is path to file with Hetzner Cloud API token.
+	TokenPath string
+	// KeepServer keeps Hetzner server, e.g. if it is managed by terraform.
+	KeepServer bool
+	// Force deletes server of node that is not found in cluster,
+	// even if role of server is unknown.
+	Force bool
+
+	// hcloudEndpoint overrides Hetzner Cloud API endpoint in tests.
+	hcloudEndpoint string
+}
+
+// kubeNode is subset of Node object.
+type kubeNode struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Status struct {
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+func (n kubeNode) controlPlane() bool {
+	_, ok := n.Metadata.Labels[controlPlaneLabel]
+	return ok
+}
+
+func (n kubeNode) internalIP() string {
+	for _, a := range n.Status.Addresses {
+		if a.Type == "InternalIP" {
+			return a.Address
+		}
+	}
+	return ""
+}
+
+// serverControlPlane reports whether server is control plane node, by its
+// role label or name given by terraform. The ok is false if role is unknown.
+func serverControlPlane(s *hcloud.Server) (controlPlane, ok bool) {
+	switch s.Labels[serverRoleLabel] {
+	case "control-plane":
+		return true, true
+	case "worker":
+		return false, true
+	}
+	switch {
+	case s.Name == "control-plane-node", strings.HasPrefix(s.Name, "control-plane-node-"):
+		return true, true
+	case strings.HasPrefix(s.Name, "worker-node-"):
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// kubeNodes returns nodes of cluster.
+func (i *Installer) kubeNodes(ctx context.Context, kubeconfig string) ([]kubeNode, error) {
+	out, err := i.Runner.Output(ctx, kubectl(kubeconfig, "get", "nodes", "--output", "json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "get nodes")
+	}
+	var list struct {
+		Items []kubeNode `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, errors.Wrap(err, "unmarshal")
+	}
+	return list.Items, nil
+}
+
+// volumeAttachments returns names of CSI volume attachments to node.
+func (i *Installer) volumeAttachments(ctx context.Context, kubeconfig, node string) ([]string, error) {
+	out, err := i.Runner.Output(ctx, kubectl(kubeconfig, "get", "volumeattachments", "--output", "json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "get volume attachments")
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				NodeName string `json:"nodeName"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, errors.Wrap(err, "unmarshal")
+	}
+	var names []string
+	for _, a := range list.Items {
+		if a.Spec.NodeName == node {
+			names = append(names, a.Metadata.Name)
+		}
+	}
+	return names, nil
+}
+
+// waitVolumesDetached waits until CSI volumes of drained node are detached,
+// so they are not left attached to deleted server.
+func (i *Installer) waitVolumesDetached(ctx context.Context, kubeconfig, node string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		names, err := i.volumeAttachments(ctx, kubeconfig, node)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 || i.dryRun() {
+			return nil
+		}
+		slog.Info("Waiting for volumes to detach", "node", node, "attachments", names)
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "volumes %s are still attached", strings.Join(names, ", "))
+		case <-ticker.C:
+		}
+	}
+}
+
+// RemoveNode drains node, deletes it from cluster and deletes its server.
+//
+// Should be run on control plane node.
+func (i *Installer) RemoveNode(ctx context.Context, opt RemoveNodeOptions) error {
+	nodes, err := i.kubeNodes(ctx, opt.Kubeconfig)
+	if err != nil {
+		return errors.Wrap(err, "list nodes")
+	}
+	var (
+		node          *kubeNode
+		controlPlanes int
+	)
+	for j := range nodes {
+		if nodes[j].controlPlane() {
+			controlPlanes++
+		}
+		if nodes[j].Metadata.Name == opt.Name {
+			node = &nodes[j]
+		}
+	}
+	switch {
+	case node == nil && !i.dryRun():
+		// Node may be already deleted from cluster by previous attempt.
+		slog.Warn("Node not found in cluster", "node", opt.Name)
+	case node != nil && node.controlPlane():
+		if controlPlanes <= 1 {
+			return errors.Errorf("%s is the last control plane node", opt.Name)
+		}
+		if !opt.Reset {
+			slog.Warn("Removing control plane node without reset, its etcd member is not removed", "node", opt.Name)
+		}
+	}
+
+	if node != nil || i.dryRun() {
+		slog.Info("Draining node", "node", opt.Name)
+		if err := i.Runner.Run(ctx, kubectl(opt.Kubeconfig, "cordon", opt.Name)); err != nil {
+			return errors.Wrap(err, "cordon")
+		}
+		if err := i.Runner.Run(ctx, kubectl(opt.Kubeconfig,
+			"drain", opt.Name,
+			"--ignore-daemonsets",
+			"--delete-emptydir-data",
+			"--timeout", "10m",
+		)); err != nil {
+			return errors.Wrap(err, "drain")
+		}
+		if err := i.waitVolumesDetached(ctx, opt.Kubeconfig, opt.Name); err != nil {
+			return errors.Wrap(err, "wait volumes")
+		}
+		if opt.Reset {
+			ip := ""
+			if node != nil {
+				ip = node.internalIP()
+			}
+			if ip == "" && !i.dryRun() {
+				return errors.Errorf("no internal IP of %s for reset", opt.Name)
+			}
+			slog.Info("kubeadm reset", "node", opt.Name, "ip", ip)
+			if err := i.Runner.Run(ctx, Cmd{
+				Name: "ssh",
+				Args: []string{
+					"-o", "StrictHostKeyChecking=accept-new",
+					opt.SSHUser + "@" + ip,
+					"sudo", "kubeadm", "reset", "--force",
+				},
+			}); err != nil {
+				return errors.Wrap(err, "kubeadm reset")
+			}
+		}
+		slog.Info("Deleting node", "node", opt.Name)
+		if err := i.Runner.Run(ctx, kubectl(opt.Kubeconfig, "delete", "node", opt.Name, "--ignore-not-found")); err != nil {
+			return errors.Wrap(err, "delete node")
+		}
+	}
+
+	if opt.KeepServer {
+		return nil
+	}
+	slog.Info("Getting token", "path", opt.TokenPath)
+	token, err := i.Runner.ReadFile(opt.TokenPath)
+	if err != nil {
+		return errors.Wrap(err, "read hetzner cloud token")
+	}
+	opts := []hcloud.ClientOption{hcloud.WithToken(strings.TrimSpace(string(token)))}
+	if opt.hcloudEndpoint != "" {
+		opts = append(opts, hcloud.WithEndpoint(opt.hcloudEndpoint))
+	}
+	client := hcloud.NewClient(opts...)
+	server, _, err := client.Server.GetByName(ctx, opt.Name)
+	if err != nil {
+		return errors.Wrap(err, "get server")
+	}
+	if server == nil {
+		slog.Warn("Server not found", "server", opt.Name)
+		return nil
+	}
+	if node == nil && !i.dryRun() && !opt.Force {
+		// Node is not in cluster, so guards above are skipped and
+		// role of server is the only way to tell what is deleted.
+		controlPlane, ok := serverControlPlane(server)
+		if !ok {
+			return errors.Errorf("%s is not found in cluster and role of server is unknown, use --force to delete it", opt.Name)
+		}
+		if controlPlane && controlPlanes == 0 {
+			return errors.Errorf("%s may be the last control plane node, no control plane nodes found in cluster", opt.Name)
+		}
+	}
+	if i.dryRun() {
+		slog.Info("Would delete server", "server", opt.Name, "id", server.ID)
+		return nil
+	}
+	slog.Info("Deleting server", "server", opt.Name, "id", server.ID)
+	res, _, err := client.Server.DeleteWithResult(ctx, server)
+	if err != nil {
+		return errors.Wrap(err, "delete server")
+	}
+	if err := client.Action.WaitFor(ctx, res.Action); err != nil {
+		return errors.Wrap(err, "wait for server deletion")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package install
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testNodes = `{"items": [
+  {"metadata": {"name": "control-plane-node", "labels": {"node-role.kubernetes.io/control-plane": ""}}},
+  {"metadata": {"name": "worker-node-0", "labels": {}}}
+]}`
+
+// hcloudServer serves server lookup and deletion of Hetzner Cloud API,
+// reporting whether server was deleted.
+func hcloudServer(t *testing.T, name string, labels map[string]string) (string, *bool) {
+	t.Helper()
+	deleted := new(bool)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var v any
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/servers":
+			servers := []any{}
+			if r.URL.Query().Get("name") == name {
+				servers = append(servers, map[string]any{"id": 1, "name": name, "labels": labels})
+			}
+			v = map[string]any{"servers": servers}
+		case r.Method == http.MethodDelete && r.URL.Path == "/servers/1":
+			*deleted = true
+			v = map[string]any{"action": map[string]any{"id": 2, "command": "delete_server", "status": "success"}}
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s.URL, deleted
+}
+
+func TestRemoveNode(t *testing.T) {
+	const kubeconfig = "/etc/kubernetes/admin.conf"
+	getNodes := kubectl(kubeconfig, "get", "nodes", "--output", "json").String()
+	newRunner := func(nodes string) *recordingRunner {
+		r := newRecordingRunner()
+		r.Outputs = map[string]string{getNodes: nodes}
+		if err := r.WriteFile("/etc/ki/hcloud-token", []byte("token\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	t.Run("LastControlPlane", func(t *testing.T) {
+		r := newRunner(testNodes)
+		err := New(r).RemoveNode(context.Background(), RemoveNodeOptions{
+			Name:       "control-plane-node",
+			Kubeconfig: kubeconfig,
+			TokenPath:  "/etc/ki/hcloud-token",
+			KeepServer: true,
+		})
+		if err == nil || !strings.Contains(err.Error(), "last control plane node") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(r.Commands) != 1 {
+			t.Errorf("node is changed: %v", r.Commands)
+		}
+	})
+	t.Run("NodeMissing", func(t *testing.T) {
+		for _, tt := range []struct {
+			Name    string
+			Nodes   string
+			Labels  map[string]string
+			Force   bool
+			Deleted bool
+		}{
+			{Name: "worker-node-2", Nodes: testNodes, Deleted: true},
+			{Name: "control-plane-node-1", Nodes: testNodes, Deleted: true},
+			{Name: "control-plane-node-1", Nodes: `{"items": []}`},
+			{Name: "custom", Nodes: testNodes},
+			{Name: "custom", Nodes: testNodes, Labels: map[string]string{"role": "worker"}, Deleted: true},
+			{Name: "custom", Nodes: `{"items": []}`, Labels: map[string]string{"role": "control-plane"}},
+			{Name: "custom", Nodes: testNodes, Force: true, Deleted: true},
+		} {
+			endpoint, deleted := hcloudServer(t, tt.Name, tt.Labels)
+			err := New(newRunner(tt.Nodes)).RemoveNode(context.Background(), RemoveNodeOptions{
+				Name:           tt.Name,
+				Kubeconfig:     kubeconfig,
+				TokenPath:      "/etc/ki/hcloud-token",
+				Force:          tt.Force,
+				hcloudEndpoint: endpoint,
+			})
+			if tt.Deleted && err != nil {
+				t.Errorf("%s: %v", tt.Name, err)
+			}
+			if !tt.Deleted && err == nil {
+				t.Errorf("%s: expected error", tt.Name)
+			}
+			if *deleted != tt.Deleted {
+				t.Errorf("%s: deleted %v, expected %v", tt.Name, *deleted, tt.Deleted)
+			}
+		}
+	})
+}
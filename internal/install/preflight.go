@@ -0,0 +1,351 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+)
+
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "pass"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+)
+
+// CheckResult is result of single preflight check.
+type CheckResult struct {
+	Name    string      `json:"name"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message"`
+}
+
+// PreflightReport is result of all preflight checks.
+type PreflightReport struct {
+	Checks []CheckResult `json:"checks"`
+}
+
+// Failed reports whether any check failed.
+func (r *PreflightReport) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Status == CheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes human-readable report.
+func (r *PreflightReport) Print(w io.Writer) {
+	for _, c := range r.Checks {
+		_, _ = fmt.Fprintf(w, "[%s] %-20s %s\n", c.Status, c.Name, c.Message)
+	}
+}
+
+type PreflightOptions struct {
+	Config config.Config
+	// Join checks node for joining as worker instead of control plane.
+	Join bool
+	// Retry retries failed network checks with retry policy from context,
+	// because network may be not ready yet right after node boot.
+	Retry bool
+}
+
+type check struct {
+	Name string
+	Run  func(ctx context.Context) (CheckStatus, string)
+	// Network check depends on network and can be retried.
+	Network bool
+}
+
+const (
+	gib = 1 << 30
+	mib = 1 << 20
+)
+
+// Preflight checks that node is suitable for install.
+func (i *Installer) Preflight(ctx context.Context, opt PreflightOptions) *PreflightReport {
+	// Repositories are checked for default distro, if OS is not supported.
+	distro, distroErr := i.Distro()
+	if distroErr != nil {
+		distro = ubuntu("noble")
+	}
+	checks := []check{
+		{Name: "os", Run: func(context.Context) (CheckStatus, string) {
+			if distroErr != nil {
+				return CheckFail, distroErr.Error()
+			}
+			return CheckPass, distro.String()
+		}},
+		{Name: "arch", Run: func(ctx context.Context) (CheckStatus, string) {
+			arch, err := i.Arch(ctx)
+			if err != nil {
+				return CheckFail, err.Error()
+			}
+			return CheckPass, arch
+		}},
+		{Name: "cpu", Run: func(context.Context) (CheckStatus, string) { return checkCPU(opt.Join) }},
+		{Name: "memory", Run: func(context.Context) (CheckStatus, string) { return i.checkMemory(opt.Join) }},
+		{Name: "disk", Run: func(context.Context) (CheckStatus, string) { return checkDisk("/var/lib") }},
+		{Name: "cgroup", Run: i.checkCgroup},
+		{Name: "kernel", Run: i.checkKernel},
+		{Name: "ports", Run: func(context.Context) (CheckStatus, string) { return i.checkPorts(opt.Join) }},
+		{Name: "time-sync", Run: i.checkTimeSync},
+		{Name: "dns", Run: checkDNS, Network: true},
+		{Name: "repositories", Network: true, Run: func(ctx context.Context) (CheckStatus, string) {
+			return checkRepositories(ctx, distro, opt.Config)
+		}},
+		{Name: "kubernetes-state", Run: i.checkKubernetesState},
+	}
+	r := &PreflightReport{}
+	for _, c := range checks {
+		run := c.Run
+		if c.Network && opt.Retry {
+			run = retryCheck(c.Name, run)
+		}
+		status, msg := run(ctx)
+		r.Checks = append(r.Checks, CheckResult{
+			Name:    c.Name,
+			Status:  status,
+			Message: msg,
+		})
+	}
+	return r
+}
+
+// retryCheck wraps check to retry it until it stops failing.
+func retryCheck(name string, run func(ctx context.Context) (CheckStatus, string)) func(ctx context.Context) (CheckStatus, string) {
+	return func(ctx context.Context) (CheckStatus, string) {
+		var (
+			status CheckStatus
+			msg    string
+		)
+		_ = retry(ctx, "preflight "+name, func() error {
+			status, msg = run(ctx)
+			if status == CheckFail {
+				return errors.New(msg)
+			}
+			return nil
+		})
+		return status, msg
+	}
+}
+
+func checkCPU(join bool) (CheckStatus, string) {
+	n := runtime.NumCPU()
+	if !join && n < 2 {
+		return CheckFail, fmt.Sprintf("%d CPU, control plane requires at least 2", n)
+	}
+	return CheckPass, fmt.Sprintf("%d CPU", n)
+}
+
+func (i *Installer) checkMemory(join bool) (CheckStatus, string) {
+	data, err := i.Runner.ReadFile("/proc/meminfo")
+	if err != nil {
+		return CheckWarn, fmt.Sprintf("read meminfo: %v", err)
+	}
+	var totalKB int64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			totalKB, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	if totalKB == 0 {
+		return CheckWarn, "MemTotal not found in /proc/meminfo"
+	}
+	total := totalKB * 1024
+	msg := fmt.Sprintf("%d MiB", total/mib)
+	// Same minimum as kubeadm preflight.
+	if !join && total < 1700*mib {
+		return CheckFail, msg + ", control plane requires at least 1700 MiB"
+	}
+	if total < 1*gib {
+		return CheckWarn, msg + ", at least 1 GiB is recommended"
+	}
+	return CheckPass, msg
+}
+
+func checkDisk(path string) (CheckStatus, string) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return CheckWarn, fmt.Sprintf("statfs %s: %v", path, err)
+	}
+	free := uint64(st.Bavail) * uint64(st.Bsize)
+	msg := fmt.Sprintf("%d GiB free on %s", free/gib, path)
+	switch {
+	case free < 10*gib:
+		return CheckFail, msg + ", at least 10 GiB is required"
+	case free < 20*gib:
+		return CheckWarn, msg + ", at least 20 GiB is recommended"
+	default:
+		return CheckPass, msg
+	}
+}
+
+func (i *Installer) checkCgroup(context.Context) (CheckStatus, string) {
+	if i.exists("/sys/fs/cgroup/cgroup.controllers") {
+		return CheckPass, "cgroup v2"
+	}
+	return CheckWarn, "cgroup v1, support is deprecated in kubernetes"
+}
+
+// parseKernelVersion parses major and minor from release like "6.8.0-51-generic".
+func parseKernelVersion(release string) (major, minor int, ok bool) {
+	parts := strings.SplitN(strings.TrimSpace(release), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minorStr := parts[1]
+	if idx := strings.IndexFunc(minorStr, func(r rune) bool { return r < '0' || r > '9' }); idx >= 0 {
+		minorStr = minorStr[:idx]
+	}
+	minor, err = strconv.Atoi(minorStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+func (i *Installer) checkKernel(context.Context) (CheckStatus, string) {
+	data, err := i.Runner.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return CheckWarn, fmt.Sprintf("read kernel release: %v", err)
+	}
+	release := strings.TrimSpace(string(data))
+	major, minor, ok := parseKernelVersion(release)
+	if !ok {
+		return CheckWarn, fmt.Sprintf("unable to parse kernel release %q", release)
+	}
+	// https://docs.cilium.io/en/stable/operations/system_requirements/#linux-kernel
+	switch {
+	case major < 5 || (major == 5 && minor < 4):
+		return CheckFail, release + ", cilium requires at least 5.4"
+	case major == 5 && minor < 10:
+		return CheckWarn, release + ", at least 5.10 is recommended"
+	default:
+		return CheckPass, release
+	}
+}
+
+func portInUse(n int) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(n)), time.Second)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+func (i *Installer) checkPorts(join bool) (CheckStatus, string) {
+	ports := []int{10250}
+	if !join {
+		ports = append(ports, 6443, 10257, 10259, 2379, 2380)
+	}
+	if i.exists(kubeletKubeconfig) {
+		return CheckPass, "node is already initialized, ports are used by kubernetes"
+	}
+	var used []string
+	for _, p := range ports {
+		if portInUse(p) {
+			used = append(used, strconv.Itoa(p))
+		}
+	}
+	if len(used) > 0 {
+		return CheckFail, "ports in use: " + strings.Join(used, ", ")
+	}
+	return CheckPass, fmt.Sprintf("%d ports are free", len(ports))
+}
+
+func (i *Installer) checkTimeSync(ctx context.Context) (CheckStatus, string) {
+	out, err := i.Runner.Output(ctx, Cmd{
+		Name: "timedatectl",
+		Args: []string{"show", "--property=NTPSynchronized", "--value"},
+	})
+	if err != nil {
+		return CheckWarn, fmt.Sprintf("timedatectl: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "yes" {
+		return CheckWarn, "system clock is not synchronized"
+	}
+	return CheckPass, "system clock is synchronized"
+}
+
+func checkDNS(ctx context.Context) (CheckStatus, string) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	const host = "pkgs.k8s.io"
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return CheckFail, fmt.Sprintf("resolve %s: %v", host, err)
+	}
+	return CheckPass, "resolved " + host
+}
+
+// repositoryURLs returns URLs of APT and chart repositories used by install.
+func repositoryURLs(distro Distro, cfg config.Config) []string {
+	return []string{
+		distro.DockerRepo + "/gpg",
+		"https://pkgs.k8s.io/core:/stable:/" + cfg.Kubernetes.Version + "/deb/Release.key",
+		"https://helm.cilium.io/index.yaml",
+		"https://charts.hetzner.cloud/index.yaml",
+	}
+}
+
+func checkRepositories(ctx context.Context, distro Distro, cfg config.Config) (CheckStatus, string) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	var failed []string
+	for _, u := range repositoryURLs(distro, cfg) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, http.NoBody)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", u, err))
+			continue
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", u, err))
+			continue
+		}
+		_ = res.Body.Close()
+		if res.StatusCode >= http.StatusBadRequest {
+			failed = append(failed, fmt.Sprintf("%s: %s", u, res.Status))
+		}
+	}
+	if len(failed) > 0 {
+		return CheckFail, strings.Join(failed, "; ")
+	}
+	return CheckPass, "all repositories are reachable"
+}
+
+func (i *Installer) checkKubernetesState(context.Context) (CheckStatus, string) {
+	leftover := i.exists(kubeletKubeconfig) || i.exists(kubeAPIServerManifest)
+	if !leftover {
+		return CheckPass, "no existing kubernetes state"
+	}
+	initialized, err := i.Initialized()
+	if err != nil {
+		return CheckWarn, err.Error()
+	}
+	if initialized {
+		return CheckPass, "node is initialized by ki"
+	}
+	return CheckWarn, "leftover state in /etc/kubernetes, run ki reset to start from scratch"
+}
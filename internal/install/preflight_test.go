@@ -0,0 +1,35 @@
+package install
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryCheck(t *testing.T) {
+	ctx := WithRetryPolicy(context.Background(), RetryPolicy{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		MaxElapsedTime:  time.Second,
+	})
+	var attempts int
+	run := retryCheck("dns", func(context.Context) (CheckStatus, string) {
+		attempts++
+		if attempts < 3 {
+			return CheckFail, "resolve pkgs.k8s.io: no such host"
+		}
+		return CheckPass, "resolved pkgs.k8s.io"
+	})
+	if status, msg := run(ctx); status != CheckPass || attempts != 3 {
+		t.Errorf("got %s %q after %d attempts", status, msg, attempts)
+	}
+
+	// Last failure is reported when retries are exhausted.
+	ctx = WithRetryPolicy(context.Background(), RetryPolicy{})
+	run = retryCheck("dns", func(context.Context) (CheckStatus, string) {
+		return CheckFail, "resolve pkgs.k8s.io: no such host"
+	})
+	if status, msg := run(ctx); status != CheckFail || msg != "resolve pkgs.k8s.io: no such host" {
+		t.Errorf("got %s %q", status, msg)
+	}
+}
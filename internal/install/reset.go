@@ -0,0 +1,174 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/go-faster/errors"
+)
+
+type ResetOptions struct {
+	// KeepPackages keeps installed packages and binaries, only unholding them.
+	KeepPackages bool
+}
+
+// k8sPackages are installed from k8s repository and held.
+var k8sPackages = []string{"kubeadm", "kubelet", "kubectl"}
+
+// cniPaths are left by Cilium and not removed by kubeadm reset.
+var cniPaths = []string{
+	"/etc/cni/net.d",
+	"/var/lib/cni",
+	"/var/run/cilium",
+}
+
+// ciliumLinks are network interfaces created by Cilium.
+var ciliumLinks = []string{"cilium_host", "cilium_net", "cilium_vxlan"}
+
+// Reset undoes changes made by ki to node, returning summary of what was reverted.
+func (i *Installer) Reset(ctx context.Context, opt ResetOptions) ([]string, error) {
+	var summary []string
+	done := func(format string, args ...any) {
+		summary = append(summary, fmt.Sprintf(format, args...))
+	}
+	remove := func(name string) error {
+		if !i.exists(name) {
+			return nil
+		}
+		slog.Info("Removing", "path", name)
+		if err := i.Runner.RemoveAll(name); err != nil {
+			return errors.Wrapf(err, "remove %s", name)
+		}
+		done("removed %s", name)
+		return nil
+	}
+
+	// Disabling ki.service first, so it is not started on reboot during reset.
+	if i.exists(servicePath) {
+		if err := i.Systemctl(ctx, "disable", "ki.service"); err != nil {
+			return summary, errors.Wrap(err, "disable ki.service")
+		}
+	}
+	if i.exists(joinServicePath) {
+		if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"disable", "--now", "ki-join.service"}}); err != nil {
+			return summary, errors.Wrap(err, "disable ki-join.service")
+		}
+		done("stopped and disabled ki-join.service")
+	}
+	if i.exists(backupTimerPath) {
+		if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"disable", "--now", "ki-backup.timer"}}); err != nil {
+			return summary, errors.Wrap(err, "disable ki-backup.timer")
+		}
+		done("stopped and disabled ki-backup.timer")
+	}
+	for _, name := range []string{servicePath, serviceConfigPath, joinServicePath, backupServicePath, backupTimerPath} {
+		if err := remove(name); err != nil {
+			return summary, err
+		}
+	}
+	if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"daemon-reload"}}); err != nil {
+		return summary, errors.Wrap(err, "daemon-reload")
+	}
+
+	if i.exists("/usr/bin/kubeadm") {
+		slog.Info("kubeadm reset")
+		if err := i.Runner.Run(ctx, Cmd{Name: "kubeadm", Args: []string{"reset", "--force"}}); err != nil {
+			return summary, errors.Wrap(err, "kubeadm reset")
+		}
+		done("kubeadm reset")
+	}
+	if i.exists("/usr/lib/systemd/system/kubelet.service") {
+		for _, action := range []string{"stop", "disable"} {
+			if err := i.Systemctl(ctx, action, "kubelet"); err != nil {
+				return summary, errors.Wrapf(err, "%s kubelet", action)
+			}
+		}
+		done("stopped and disabled kubelet")
+	}
+
+	// CNI state.
+	for _, name := range cniPaths {
+		if err := remove(name); err != nil {
+			return summary, err
+		}
+	}
+	for _, link := range ciliumLinks {
+		if !i.exists(filepath.Join("/sys/class/net", link)) {
+			continue
+		}
+		if err := i.Runner.Run(ctx, Cmd{Name: "ip", Args: []string{"link", "delete", link}}); err != nil {
+			return summary, errors.Wrapf(err, "delete link %s", link)
+		}
+		done("deleted link %s", link)
+	}
+
+	// Packages.
+	if i.exists("/usr/bin/apt-mark") {
+		if err := i.APTUnhold(ctx, k8sPackages...); err != nil {
+			return summary, err
+		}
+		done("unheld %v", k8sPackages)
+	}
+	if !opt.KeepPackages {
+		containerd := "containerd.io"
+		if distro, err := i.Distro(); err == nil {
+			containerd = distro.Containerd
+		}
+		packages := append(append([]string{}, k8sPackages...), containerd)
+		slog.Info("apt-get purge", "packages", packages)
+		if err := i.Runner.Run(ctx, Cmd{
+			Name: "apt-get",
+			Args: append([]string{"purge", "-y", "--allow-change-held-packages"}, packages...),
+			Env:  debianFrontend(),
+		}); err != nil {
+			return summary, errors.Wrap(err, "apt purge")
+		}
+		done("purged %v", packages)
+		for _, name := range []string{
+			binaryPath("helm"),
+			binaryPath("cilium"),
+			containerdConfigPath,
+		} {
+			if err := remove(name); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	// Files written by install steps.
+	userKubeconfig, err := userKubeconfigPath()
+	if err != nil {
+		return summary, errors.Wrap(err, "user kubeconfig")
+	}
+	for _, name := range []string{
+		aptListPath("docker"),
+		aptListPath("k8s"),
+		aptKeyPath("docker"),
+		aptKeyPath("k8s"),
+		modulesLoadPath("containerd"),
+		sysctlPath("kubernetes"),
+		initParamsPath,
+		kubeadmInitConfigPath,
+		kubeadmJoinConfigPath,
+		ciliumValuesPath,
+		hccmValuesPath,
+		userKubeconfig,
+		filepath.Dir(StatePath),
+	} {
+		if err := remove(name); err != nil {
+			return summary, err
+		}
+	}
+
+	restored, err := i.RestoreSwap(ctx)
+	if err != nil {
+		return summary, errors.Wrap(err, "restore swap")
+	}
+	if restored {
+		done("restored swap in %s", fstabPath)
+	}
+
+	return summary, nil
+}
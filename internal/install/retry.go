@@ -0,0 +1,123 @@
+package install
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/go-faster/errors"
+)
+
+// RetryPolicy configures retries of network-dependent operations,
+// like downloads, apt-get update and helm.
+type RetryPolicy struct {
+	// InitialInterval is delay before first retry, doubled on each attempt.
+	InitialInterval time.Duration
+	// MaxInterval limits delay between retries.
+	MaxInterval time.Duration
+	// MaxElapsedTime limits total time of all attempts, zero disables retries.
+	MaxElapsedTime time.Duration
+}
+
+// DefaultRetryPolicy covers flaky network during first minutes after node boot.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialInterval: 2 * time.Second,
+	MaxInterval:     30 * time.Second,
+	MaxElapsedTime:  5 * time.Minute,
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns context with retry policy for operations
+// executed with it.
+func WithRetryPolicy(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, p)
+}
+
+func retryPolicy(ctx context.Context) RetryPolicy {
+	if p, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		return p
+	}
+	return DefaultRetryPolicy
+}
+
+func (p RetryPolicy) backOff() backoff.BackOff {
+	if p.MaxElapsedTime <= 0 {
+		return &backoff.StopBackOff{}
+	}
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = p.InitialInterval
+	b.MaxInterval = p.MaxInterval
+	b.MaxElapsedTime = p.MaxElapsedTime
+	return b
+}
+
+// permanent marks error as not worth retrying.
+func permanent(err error) error {
+	return backoff.Permanent(err)
+}
+
+// retry runs operation until it succeeds, returns permanent error or
+// retry policy from context is exhausted.
+//
+// Failed commands are considered transient, except missing binaries.
+func retry(ctx context.Context, op string, fn func() error) error {
+	p := retryPolicy(ctx)
+	attempt := 1
+	return backoff.RetryNotify(func() error {
+		err := fn()
+		if errors.Is(err, exec.ErrNotFound) {
+			return permanent(err)
+		}
+		return err
+	}, backoff.WithContext(p.backOff(), ctx), func(err error, d time.Duration) {
+		slog.Warn("Retrying",
+			"op", op,
+			"attempt", attempt,
+			"delay", d,
+			"error", err,
+		)
+		attempt++
+	})
+}
+
+// httpGet downloads url to w, see statusError for retried statuses.
+func httpGet(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return permanent(errors.Wrap(err, "create request"))
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "get")
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return statusError(res)
+	}
+	if _, err := io.Copy(w, res.Body); err != nil {
+		return errors.Wrap(err, "read body")
+	}
+	return nil
+}
+
+// statusError returns error for unexpected response status.
+//
+// Client errors, except timeouts and rate limits, are permanent.
+func statusError(res *http.Response) error {
+	err := errors.Errorf("bad status: %s", res.Status)
+	switch {
+	case res.StatusCode == http.StatusRequestTimeout,
+		res.StatusCode == http.StatusTooManyRequests,
+		res.StatusCode >= http.StatusInternalServerError:
+		return err
+	default:
+		return permanent(err)
+	}
+}
@@ -1,8 +1,11 @@
 package install
 
 import (
+	"context"
 	"encoding/json"
-	"os/exec"
+	"log/slog"
+	"net"
+	"strings"
 
 	"github.com/go-faster/errors"
 )
@@ -23,21 +26,94 @@ type Metric struct {
 	MTU int `json:"mtu"`
 }
 
-func GetDefaultGatewayIP() (string, error) {
-	// This is valid for hetzher.
-	cmd := exec.Command("ip", "-j", "route", "show", "default")
-	out, err := cmd.Output()
+func (i *Installer) defaultRoute(ctx context.Context) (*Route, error) {
+	out, err := i.Runner.Output(ctx, Cmd{
+		Name: "ip",
+		Args: []string{"-j", "route", "show", "default"},
+	})
 	if err != nil {
-		return "", errors.Wrap(err, "ip route show default")
+		return nil, errors.Wrap(err, "ip route show default")
 	}
 	var routes []Route
 	if err := json.Unmarshal(out, &routes); err != nil {
-		return "", errors.Wrap(err, "unmarshal")
+		return nil, errors.Wrap(err, "unmarshal")
 	}
 	for _, route := range routes {
 		if route.Dst == "default" {
-			return route.PrefSrc, nil
+			return &route, nil
+		}
+	}
+	return nil, errors.New("default gateway not found")
+}
+
+// GetDefaultGatewayIP returns preferred source address of default route,
+// which is public IP on Hetzner.
+func (i *Installer) GetDefaultGatewayIP(ctx context.Context) (string, error) {
+	route, err := i.defaultRoute(ctx)
+	if err != nil {
+		return "", err
+	}
+	return route.PrefSrc, nil
+}
+
+// Link is network interface with addresses.
+type Link struct {
+	Name     string `json:"ifname"`
+	Address  string `json:"address"` // MAC address
+	AddrInfo []struct {
+		Family string `json:"family"`
+		Local  string `json:"local"`
+	} `json:"addr_info"`
+}
+
+// ipv4 returns first IPv4 address of interface.
+func (l Link) ipv4() string {
+	for _, a := range l.AddrInfo {
+		if a.Family == "inet" {
+			return a.Local
+		}
+	}
+	return ""
+}
+
+// PrivateIP returns IPv4 address of interface attached to Hetzner private
+// network.
+//
+// Interface is found by MAC address from metadata service, if set.
+// Otherwise, first interface with private address, except interface of
+// default route, is used.
+func (i *Installer) PrivateIP(ctx context.Context, mac string) (string, error) {
+	out, err := i.Runner.Output(ctx, Cmd{
+		Name: "ip",
+		Args: []string{"-j", "-4", "address", "show"},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "ip address show")
+	}
+	var links []Link
+	if err := json.Unmarshal(out, &links); err != nil {
+		return "", errors.Wrap(err, "unmarshal")
+	}
+	if mac != "" {
+		for _, l := range links {
+			if strings.EqualFold(l.Address, mac) && l.ipv4() != "" {
+				slog.Info("Private network interface", "name", l.Name, "ip", l.ipv4())
+				return l.ipv4(), nil
+			}
+		}
+		return "", errors.Errorf("no interface with address %s", mac)
+	}
+	route, err := i.defaultRoute(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "default route")
+	}
+	for _, l := range links {
+		ip := net.ParseIP(l.ipv4())
+		if l.Name == route.Dev || ip == nil || !ip.IsPrivate() {
+			continue
 		}
+		slog.Info("Private network interface", "name", l.Name, "ip", ip)
+		return ip.String(), nil
 	}
-	return "", errors.New("default gateway not found")
+	return "", errors.New("no private network interface found")
 }
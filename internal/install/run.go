@@ -2,51 +2,40 @@ package install
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
-	"flag"
-	"fmt"
+	"log/slog"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"text/template"
 
 	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
 )
 
 func appendEnv(vars []string, key, value string) []string {
 	return append(vars, key+"="+value)
 }
 
-func lsbRelease() (string, error) {
-	cmd := exec.Command("lsb_release", "-cs")
-	out, err := cmd.Output()
-	if err != nil {
-		return "", errors.Wrap(err, "lsb_release")
-	}
-	return string(bytes.TrimSpace(out)), nil
-}
-
 func CheckTCPPortIsFree(n int) error {
 	// nc 127.0.0.1 6443 -v
 	// ^ should fail, but in go.
-	fmt.Printf("> Checking port %d\n", n)
+	slog.Info("Checking port", "port", n)
 	tcpAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: n}
 	conn, err := net.DialTCP("tcp", nil, tcpAddr)
 	if err != nil {
-		fmt.Printf("> Port %d is free\n", n)
+		slog.Info("Port is free", "port", n)
 		return nil
 	}
 	_ = conn.Close()
 	return errors.Errorf("port %d is in use", n)
 }
 
-func Systemctl(action, service string) error {
-	fmt.Printf("> systemctl %s %s\n", action, service)
-	cmd := exec.Command("systemctl", action, service)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
+func (i *Installer) Systemctl(ctx context.Context, action, service string) error {
+	slog.Info("systemctl", "action", action, "service", service)
+	if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{action, service}}); err != nil {
 		return errors.Wrap(err, "systemctl")
 	}
 	return nil
@@ -55,6 +44,9 @@ func Systemctl(action, service string) error {
 //go:embed cilium.yml.tmpl
 var ciliumConfigTemplate string
 
+// ciliumValuesPath is relative to working directory, which is /root for ki.service.
+const ciliumValuesPath = "cilium.yml"
+
 type CiliumConfig struct {
 	K8sServiceHost string // 1.1.1.1
 }
@@ -64,7 +56,7 @@ type CiliumInstallOptions struct {
 	K8sServiceHost string
 }
 
-func CiliumInstall(opt CiliumInstallOptions) error {
+func (i *Installer) CiliumInstall(ctx context.Context, opt CiliumInstallOptions) error {
 	// Should be installed via helm.
 	// helm upgrade --version 1.13.2 --install --create-namespace --namespace "cilium" cilium cilium/cilium --values cilium.yml
 	// 1. Render template.
@@ -80,13 +72,12 @@ func CiliumInstall(opt CiliumInstallOptions) error {
 	}
 
 	// Write to file.
-	fileName := "cilium.yml"
-	fmt.Printf("> Writing %s\n", fileName)
-	if err := os.WriteFile(fileName, buf.Bytes(), 0600); err != nil {
+	fileName := ciliumValuesPath
+	if _, err := i.writeFile(fileName, buf.Bytes(), 0600); err != nil {
 		return errors.Wrap(err, "write")
 	}
 
-	if err := HelmUpgrade(HelmUpgradeOptions{
+	if err := i.HelmUpgrade(ctx, HelmUpgradeOptions{
 		Version:         opt.Version,
 		Name:            "cilium",
 		Install:         true,
@@ -101,10 +92,11 @@ func CiliumInstall(opt CiliumInstallOptions) error {
 	return nil
 }
 
-func ConfigureContainerd() error {
+const containerdConfigPath = "/etc/containerd/config.toml"
+
+func (i *Installer) ConfigureContainerd(ctx context.Context) error {
 	// 1. Get default config.
-	cmd := exec.Command("containerd", "config", "default")
-	out, err := cmd.Output()
+	out, err := i.Runner.Output(ctx, Cmd{Name: "containerd", Args: []string{"config", "default"}})
 	if err != nil {
 		return errors.Wrap(err, "containerd config default")
 	}
@@ -112,242 +104,181 @@ func ConfigureContainerd() error {
 	// sudo sed -i 's/SystemdCgroup = false/SystemdCgroup = true/g' /etc/containerd/config.toml
 	out = bytes.ReplaceAll(out, []byte("SystemdCgroup = false"), []byte("SystemdCgroup = true"))
 	// Write back.
-	fileName := "/etc/containerd/config.toml"
-	fmt.Printf("> Writing %s\n", fileName)
-	if err := os.WriteFile(fileName, out, 0600); err != nil {
+	fileName := containerdConfigPath
+	changed, err := i.writeFile(fileName, out, 0600)
+	if err != nil {
 		return errors.Wrap(err, "write")
 	}
-	// 3. Restart containerd.
-	if err := Systemctl("restart", "containerd"); err != nil {
-		return errors.Wrap(err, "restart containerd")
+	// 3. Restart containerd, only if config was changed.
+	if changed {
+		if err := i.Systemctl(ctx, "restart", "containerd"); err != nil {
+			return errors.Wrap(err, "restart containerd")
+		}
 	}
 	// 4. Enable containerd.
-	if err := Systemctl("enable", "containerd"); err != nil {
+	if err := i.Systemctl(ctx, "enable", "containerd"); err != nil {
 		return errors.Wrap(err, "enable containerd")
 	}
-	fmt.Println("> Configured, restarted and enabled containerd")
+	slog.Info("Configured and enabled containerd")
 	return nil
 }
 
 const serviceMonitorCRD = "https://raw.githubusercontent.com/prometheus-operator/prometheus-operator/" +
 	"main/example/prometheus-operator-crd/monitoring.coreos.com_servicemonitors.yaml"
 
-func SetupKubeconfig() error {
-	const kubeConfig = "/etc/kubernetes/admin.conf"
-	fmt.Println("> Setting up kubeconfig")
+func userKubeconfigPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return errors.Wrap(err, "user home dir")
+		return "", errors.Wrap(err, "user home dir")
 	}
-	kubeDir := filepath.Join(homeDir, ".kube")
-	if err := os.MkdirAll(kubeDir, 0750); err != nil {
-		return errors.Wrap(err, "mkdir")
-	}
-	data, err := os.ReadFile(kubeConfig)
+	return filepath.Join(homeDir, ".kube", "config"), nil
+}
+
+func (i *Installer) SetupKubeconfig(ctx context.Context) error {
+	slog.Info("Setting up kubeconfig")
+	userKubeconfig, err := userKubeconfigPath()
 	if err != nil {
-		return errors.Wrap(err, "read")
+		return errors.Wrap(err, "user kubeconfig")
 	}
-	if err := os.WriteFile(filepath.Join(kubeDir, "config"), data, 0600); err != nil {
-		return errors.Wrap(err, "write")
+	kubeDir := filepath.Dir(userKubeconfig)
+	if err := i.Runner.MkdirAll(kubeDir, 0750); err != nil {
+		return errors.Wrap(err, "mkdir")
+	}
+	// Copying with install, because admin.conf is created by kubeadm
+	// and is not available before it runs.
+	if err := i.Runner.Run(ctx, Cmd{
+		Name: "install",
+		Args: []string{"-m", "0600", adminKubeconfig, userKubeconfig},
+	}); err != nil {
+		return errors.Wrap(err, "install")
 	}
-	fmt.Println("> Kubeconfig is ready")
+	slog.Info("Kubeconfig is ready", "path", userKubeconfig)
 	return nil
 }
 
-func Run() error {
-	var arg struct {
-		Version                string
-		Join                   bool
-		CiliumVersion          string
-		CiliumCliVersion       string
-		CiliumCliSHA256        string
-		HelmVersion            string
-		HelmSHA256             string
-		ControlPlaneInternalIP string
-		Install                bool
-	}
-	flag.StringVar(&arg.Version, "version", "v1.31", "kubernetes version")
-	flag.StringVar(&arg.HelmVersion, "helm-version", "v3.17.0", "helm version")
-	flag.StringVar(&arg.HelmSHA256, "helm-sha256", "fb5d12662fde6eeff36ac4ccacbf3abed96b0ee2de07afdde4edb14e613aee24", "helm sha256")
-	flag.StringVar(&arg.CiliumVersion, "cilium-version", "1.17.0", "cilium version")
-	flag.StringVar(&arg.CiliumCliVersion, "cilium-cli-version", "v0.16.24", "cilium cli version")
-	flag.StringVar(&arg.CiliumCliSHA256, "cilium-cli-sha256", "019c9c765222b3db5786f7b3a0bff2cd62944a8ce32681acfb47808330f405a7", "cilium cli sha256")
-	flag.BoolVar(&arg.Join, "join", false, "join cluster")
-	flag.StringVar(&arg.ControlPlaneInternalIP, "control-plane-internal-ip", "10.0.1.1", "control plane internal ip")
-	flag.BoolVar(&arg.Install, "install", false, "install")
-	flag.Parse()
+type RunOptions struct {
+	Config config.Config
+	// Join joins node to existing cluster instead of initializing new one.
+	Join bool
+	// ControlPlane joins node as control plane instead of worker.
+	ControlPlane bool
+	Steps        RunStepsOptions
+	// SkipPreflight skips preflight checks.
+	SkipPreflight bool
+}
 
-	// Check OS.
-	release, err := lsbRelease()
+// Run installs node and initializes or joins cluster.
+func (i *Installer) Run(ctx context.Context, opt RunOptions) error {
+	cfg := opt.Config
+	distro, err := i.Distro()
 	if err != nil {
-		return errors.Wrap(err, "lsb_release")
-	}
-	fmt.Println("> OS release:", release)
-	supported := map[string]struct{}{
-		"noble": {},
-	}
-	if _, ok := supported[release]; !ok {
-		return errors.Errorf("unsupported OS: %s", release)
+		return errors.Wrap(err, "detect OS")
 	}
-	defaultGateway, err := GetDefaultGatewayIP()
+	arch, err := i.Arch(ctx)
 	if err != nil {
-		return errors.Wrap(err, "get default gateway")
+		return errors.Wrap(err, "detect architecture")
 	}
-	fmt.Println("> Default gateway:", defaultGateway)
-	// Check required ports
-	if err := CheckTCPPortIsFree(6443); err != nil {
-		return errors.Wrap(err, "check k8s port")
-	}
-
-	if arg.Install {
-		// Only installing as service, not running.
-		if err := Service(ServiceOptions{
-			Join: arg.Join,
-		}); err != nil {
-			return errors.Wrap(err, "service")
+	slog.Info("OS release", "id", distro.ID, "codename", distro.Codename, "arch", arch)
+	node, err := i.NodeIdentity(ctx, NodeIdentityOptions{
+		MetadataURL: cfg.Hetzner.MetadataURL,
+		Network:     cfg.Hetzner.Network,
+	})
+	if err != nil {
+		return errors.Wrap(err, "node identity")
+	}
+	if node == nil {
+		node = &NodeIdentity{}
+	}
+	// Cluster traffic is kept in private network. Interface may get its
+	// address some time after boot.
+	var privateIP string
+	getPrivateIP := func() (err error) {
+		privateIP, err = i.PrivateIP(ctx, node.MACAddress)
+		return err
+	}
+	if i.dryRun() {
+		if err := getPrivateIP(); err != nil {
+			slog.Warn("Private network interface not found, using controlPlane.internalIP", "error", err)
+			privateIP = cfg.ControlPlane.InternalIP
 		}
-		fmt.Println("> Done")
-		return nil
+	} else if err := retry(ctx, "private ip", getPrivateIP); err != nil {
+		return errors.Wrap(err, "get private ip")
 	}
-
-	if err := InstallBinary(Binary{
-		Name:   "helm",
-		URL:    "https://get.helm.sh/helm-" + arg.HelmVersion + "-linux-amd64.tar.gz",
-		SHA256: arg.HelmSHA256,
-	}); err != nil {
-		return errors.Wrap(err, "install helm")
+	if node.IP != "" && node.IP != privateIP {
+		slog.Warn("Private IP of interface does not match metadata", "ip", privateIP, "metadata_ip", node.IP)
 	}
-
-	// https://github.com/cilium/cilium-cli/releases/
-	if err := InstallBinary(Binary{
-		Name:   "cilium",
-		URL:    "https://github.com/cilium/cilium-cli/releases/download/" + arg.CiliumCliVersion + "/cilium-linux-amd64.tar.gz",
-		SHA256: arg.CiliumCliSHA256,
-	}); err != nil {
-		return errors.Wrap(err, "install cilium")
+	node.IP = privateIP
+	if !opt.Join && privateIP != cfg.ControlPlane.InternalIP {
+		slog.Warn("Private IP of node does not match controlPlane.internalIP, nodes will not be able to join",
+			"ip", privateIP,
+			"internal_ip", cfg.ControlPlane.InternalIP,
+		)
 	}
 
-	// Swap configuration
-	if err := DisableSwap(); err != nil {
-		return errors.Wrap(err, "disable swap")
-	}
-	//  Update apt cache
-	if err := APTUpdate(); err != nil {
-		return errors.Wrap(err, "apt update")
-	}
-	//  Upgrade packages
-	if err := APTUpgrade(); err != nil {
-		return errors.Wrap(err, "apt upgrade")
-	}
-	// Installing a container runtime.
-	if err := LoadKernelModules("containerd", "overlay", "br_netfilter"); err != nil {
-		return errors.Wrap(err, "load kernel modules")
-	}
-	if err := ConfigureKernelParameters("kubernetes", map[string]any{
-		"net.bridge.bridge-nf-call-ip6tables": 1,
-		"net.bridge.bridge-nf-call-iptables":  1,
-		"net.ipv4.ip_forward":                 1,
-	}); err != nil {
-		return errors.Wrap(err, "configure kernel parameters")
-	}
-	fmt.Println("> Installing containerd")
-	if err := APTInstall("curl", "gnupg2", "software-properties-common", "apt-transport-https", "ca-certificates"); err != nil {
-		return errors.Wrap(err, "install containerd dependencies")
-	}
-	if err := APTKey("docker", "https://download.docker.com/linux/ubuntu/gpg"); err != nil {
-		return errors.Wrap(err, "add docker key")
-	}
-	if err := APTAddRepo(APTAddRepoOptions{
-		Name:       "docker",
-		URL:        "https://download.docker.com/linux/ubuntu",
-		SignedBy:   "/etc/apt/keyrings/docker.gpg",
-		Arch:       []string{"amd64"},
-		Components: []string{release, "stable"},
-	}); err != nil {
-		return errors.Wrap(err, "add docker repo")
-	}
-	if err := APTUpdate(); err != nil {
-		return errors.Wrap(err, "apt update")
-	}
-	if err := APTInstall("curl", "containerd.io"); err != nil {
-		return errors.Wrap(err, "install containerd")
-	}
-	if err := ConfigureContainerd(); err != nil {
-		return errors.Wrap(err, "configure containerd")
-	}
-	// Install k8s
-	fmt.Println("> Installing k8s")
-	if err := APTKey("k8s", "https://pkgs.k8s.io/core:/stable:/"+arg.Version+"/deb/Release.key"); err != nil {
-		return errors.Wrap(err, "add k8s key")
-	}
-	if err := APTAddRepo(APTAddRepoOptions{
-		Name:       "k8s",
-		URL:        "https://pkgs.k8s.io/core:/stable:/" + arg.Version + "/deb/",
-		SignedBy:   "/etc/apt/keyrings/k8s.gpg",
-		Components: []string{"/"},
-	}); err != nil {
-		return errors.Wrap(err, "add k8s repo")
-	}
-	if err := APTUpdate(); err != nil {
-		return errors.Wrap(err, "apt update")
-	}
-	if err := APTInstall("kubeadm", "kubelet", "kubectl"); err != nil {
-		return errors.Wrap(err, "install k8s")
-	}
-	if err := APTHold("kubeadm", "kubelet", "kubectl"); err != nil {
-		return errors.Wrap(err, "hold k8s")
-	}
-	// Enable and start kubelet
-	fmt.Println("> Starting kubelet")
-	if err := Systemctl("enable", "kubelet"); err != nil {
-		return errors.Wrap(err, "enable kubelet")
-	}
-	if err := Systemctl("start", "kubelet"); err != nil {
-		return errors.Wrap(err, "start kubelet")
-	}
-	// Initialize k8s
-	fmt.Println("> Initializing k8s")
-	if arg.Join {
-		if err := KubeadmJoin(arg.ControlPlaneInternalIP); err != nil {
-			return errors.Wrap(err, "kubeadm join")
+	initialized, err := i.Initialized()
+	if err != nil {
+		return errors.Wrap(err, "check state")
+	}
+	switch {
+	case opt.SkipPreflight:
+	case initialized:
+		// Ports are taken by cluster itself and network checks would
+		// delay every restart of ki.service.
+		slog.Info("Node is already initialized, skipping preflight checks")
+	default:
+		slog.Info("Preflight checks")
+		// Network may be not ready yet right after boot.
+		report := i.Preflight(WithRetryPolicy(ctx, opt.Steps.retry("preflight")), PreflightOptions{
+			Config: cfg,
+			Join:   opt.Join && !opt.ControlPlane,
+			Retry:  true,
+		})
+		report.Print(os.Stdout)
+		if report.Failed() {
+			return errors.New("preflight checks failed")
 		}
-		fmt.Println("> Joined")
-		return nil
-	}
-	if err := KubeadmInit(KubeadmInitOptions{
-		SkipPhases:           []string{"addon/kube-proxy"},
-		PodNetworkCIDR:       "10.244.0.0/16",
-		ServiceCIDR:          "10.96.0.0/16",
-		ControlPlaneEndpoint: defaultGateway,
-		ExtraSans:            []string{arg.ControlPlaneInternalIP},
-	}); err != nil {
-		return errors.Wrap(err, "kubeadm init")
-	}
-	if err := SetupKubeconfig(); err != nil {
-		return errors.Wrap(err, "setup kubeconfig")
-	}
-	// Install cilium.
-	if err := KubectlApply(KubectlApplyOptions{
-		File: serviceMonitorCRD,
-	}); err != nil {
-		return errors.Wrap(err, "kubectl apply service monitor CRD")
 	}
-	fmt.Println("> Installing cilium")
-	if err := HelmAddRepo("cilium", "https://helm.cilium.io"); err != nil {
-		return errors.Wrap(err, "helm add repo")
-	}
-	if err := CiliumInstall(CiliumInstallOptions{
-		Version:        arg.CiliumVersion,
-		K8sServiceHost: defaultGateway,
-	}); err != nil {
-		return errors.Wrap(err, "cilium install")
-	}
-	if err := DefaultIngress(); err != nil {
-		return errors.Wrap(err, "default ingress")
-	}
-	if err := HetznerCloudInstall(); err != nil {
-		return errors.Wrap(err, "hetzner cloud install")
-	}
-	fmt.Println("> Done")
+
+	steps := NodeSteps(i, NodeOptions{
+		Distro: distro,
+		Arch:   arch,
+		Config: cfg,
+	})
+	if opt.Join {
+		steps = append(steps, JoinSteps(i, JoinOptions{
+			ControlPlaneInternalIP: cfg.ControlPlane.InternalIP,
+			ControlPlane:           opt.ControlPlane,
+			Config:                 cfg,
+			Node:                   node,
+			AdvertiseAddress:       privateIP,
+		})...)
+	} else {
+		endpoint := cfg.ControlPlane.Endpoint
+		if endpoint == "" {
+			endpoint = privateIP
+		}
+		// Public IP is only added to API server certificate, for kubectl
+		// access from outside of private network.
+		var publicIP string
+		if cfg.ControlPlane.PublicSAN {
+			if node.Metadata != nil && node.Metadata.PublicIPv4 != "" {
+				publicIP = node.Metadata.PublicIPv4
+			} else if publicIP, err = i.GetDefaultGatewayIP(ctx); err != nil {
+				return errors.Wrap(err, "get public ip")
+			}
+			slog.Info("Public IP", "ip", publicIP)
+		}
+		steps = append(steps, ControlPlaneSteps(i, ControlPlaneOptions{
+			ControlPlaneEndpoint: endpoint,
+			Config:               cfg,
+			Node:                 node,
+			AdvertiseAddress:     privateIP,
+			PublicIP:             publicIP,
+		})...)
+	}
+	if err := i.RunSteps(ctx, steps, opt.Steps); err != nil {
+		return errors.Wrap(err, "run steps")
+	}
+	slog.Info("Done")
 	return nil
 }
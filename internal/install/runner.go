@@ -0,0 +1,240 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-faster/errors"
+)
+
+// Cmd describes command to run on node.
+type Cmd struct {
+	Name string
+	Args []string
+	// Env is appended to current process environment.
+	Env []string
+	Dir string
+
+	Stdin io.Reader
+	// Stdout overrides runner output, e.g. to capture it.
+	Stdout io.Writer
+	// Redact hides arguments in logs, because they contain secrets.
+	Redact bool
+}
+
+func (c Cmd) String() string {
+	if c.Redact {
+		return c.Name + " <redacted>"
+	}
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Runner executes commands and writes files on node.
+//
+// All install steps go through Runner, so it can be replaced
+// to preview changes (DryRunner) or to test steps without root.
+type Runner interface {
+	// Run executes command that changes node state.
+	//
+	// Command is terminated when context is done.
+	Run(ctx context.Context, cmd Cmd) error
+	// Output executes command that only reads node state and returns its stdout.
+	Output(ctx context.Context, cmd Cmd) ([]byte, error)
+	// ReadFile reads file from node.
+	ReadFile(name string) ([]byte, error)
+	// WriteFile writes file to node, creating or truncating it.
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	// MkdirAll creates directory on node with all parents.
+	MkdirAll(name string, perm os.FileMode) error
+	// Stat returns file info from node.
+	Stat(name string) (os.FileInfo, error)
+	// RemoveAll removes file or directory from node, if it exists.
+	RemoveAll(name string) error
+}
+
+// ExecRunner is Runner that changes local node.
+type ExecRunner struct {
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// NewExecRunner returns ExecRunner that writes command output to stdout and stderr.
+func NewExecRunner() *ExecRunner {
+	return &ExecRunner{
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+// killDelay is time given to command to exit after SIGTERM before it is killed.
+const killDelay = 10 * time.Second
+
+func (r *ExecRunner) command(ctx context.Context, c Cmd) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	// Running in separate process group, so whole process tree
+	// (e.g. dpkg spawned by apt-get) is terminated on cancellation.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = killDelay
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.Dir = c.Dir
+	cmd.Stdin = c.Stdin
+	cmd.Stderr = r.Stderr
+	cmd.Stdout = r.Stdout
+	if c.Stdout != nil {
+		cmd.Stdout = c.Stdout
+	}
+	return cmd
+}
+
+// log logs finished command.
+func (r *ExecRunner) log(c Cmd, start time.Time, err error) {
+	attrs := []any{
+		"cmd", c.String(),
+		"duration", time.Since(start),
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		attrs = append(attrs, "exit_code", 0)
+	case errors.As(err, &exitErr):
+		attrs = append(attrs, "exit_code", exitErr.ExitCode(), "error", err)
+	default:
+		attrs = append(attrs, "error", err)
+	}
+	if err != nil {
+		slog.Warn("Command failed", attrs...)
+		return
+	}
+	slog.Info("Command finished", attrs...)
+}
+
+func (r *ExecRunner) Run(ctx context.Context, c Cmd) error {
+	start := time.Now()
+	err := r.command(ctx, c).Run()
+	r.log(c, start, err)
+	return err
+}
+
+func (r *ExecRunner) Output(ctx context.Context, c Cmd) ([]byte, error) {
+	cmd := r.command(ctx, c)
+	cmd.Stdout = nil
+	start := time.Now()
+	out, err := cmd.Output()
+	r.log(c, start, err)
+	return out, err
+}
+
+func (r *ExecRunner) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (r *ExecRunner) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (r *ExecRunner) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (r *ExecRunner) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (r *ExecRunner) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+// DryRunner is Runner that prints commands and files instead of changing node.
+//
+// Read-only commands and file reads are executed for real, because
+// next steps depend on their results. If read-only command is not
+// available yet (e.g. it is installed by one of previous steps), it
+// is printed and treated as producing empty output.
+type DryRunner struct {
+	Out io.Writer
+}
+
+// NewDryRunner returns DryRunner that prints to stdout.
+func NewDryRunner() *DryRunner {
+	return &DryRunner{Out: os.Stdout}
+}
+
+func (r *DryRunner) printf(format string, args ...any) {
+	_, _ = fmt.Fprintf(r.Out, "[dry-run] "+format, args...)
+}
+
+func (r *DryRunner) printContent(data []byte) {
+	if !utf8.Valid(data) {
+		_, _ = fmt.Fprintf(r.Out, "<%d bytes of binary data>\n", len(data))
+		return
+	}
+	_, _ = r.Out.Write(data)
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		_, _ = fmt.Fprintln(r.Out)
+	}
+}
+
+func (r *DryRunner) Run(ctx context.Context, c Cmd) error {
+	r.printf("$ %s\n", c)
+	if c.Dir != "" {
+		r.printf("  in %s\n", c.Dir)
+	}
+	for _, v := range c.Env {
+		r.printf("  env %s\n", v)
+	}
+	if c.Stdin != nil {
+		data, err := io.ReadAll(c.Stdin)
+		if err != nil {
+			return errors.Wrap(err, "read stdin")
+		}
+		r.printf("  stdin:\n")
+		r.printContent(data)
+	}
+	return nil
+}
+
+func (r *DryRunner) Output(ctx context.Context, c Cmd) ([]byte, error) {
+	if _, err := exec.LookPath(c.Name); err != nil {
+		r.printf("$ %s (not available yet)\n", c)
+		return nil, nil
+	}
+	return NewExecRunner().Output(ctx, c)
+}
+
+func (r *DryRunner) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (r *DryRunner) WriteFile(name string, data []byte, perm os.FileMode) error {
+	r.printf("write %s (%s):\n", name, perm)
+	r.printContent(data)
+	return nil
+}
+
+func (r *DryRunner) MkdirAll(name string, perm os.FileMode) error {
+	r.printf("mkdir -p %s (%s)\n", name, perm)
+	return nil
+}
+
+func (r *DryRunner) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (r *DryRunner) RemoveAll(name string) error {
+	r.printf("rm -rf %s\n", name)
+	return nil
+}
@@ -0,0 +1,139 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// recordingRunner is Runner that records commands and keeps files in memory.
+type recordingRunner struct {
+	Commands []string
+	Files    fstest.MapFS
+	// Outputs are returned by Output for command strings.
+	Outputs map[string]string
+}
+
+func newRecordingRunner() *recordingRunner {
+	return &recordingRunner{Files: fstest.MapFS{}}
+}
+
+func (r *recordingRunner) Run(ctx context.Context, c Cmd) error {
+	r.Commands = append(r.Commands, c.String())
+	return nil
+}
+
+func (r *recordingRunner) Output(ctx context.Context, c Cmd) ([]byte, error) {
+	r.Commands = append(r.Commands, c.String())
+	return []byte(r.Outputs[c.String()]), nil
+}
+
+func (r *recordingRunner) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(r.Files, strings.TrimPrefix(name, "/"))
+}
+
+func (r *recordingRunner) WriteFile(name string, data []byte, perm os.FileMode) error {
+	r.Files[strings.TrimPrefix(name, "/")] = &fstest.MapFile{Data: bytes.Clone(data), Mode: perm}
+	return nil
+}
+
+func (r *recordingRunner) MkdirAll(name string, perm os.FileMode) error {
+	return nil
+}
+
+func (r *recordingRunner) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(r.Files, strings.TrimPrefix(name, "/"))
+}
+
+func (r *recordingRunner) RemoveAll(name string) error {
+	r.Commands = append(r.Commands, "rm -rf "+name)
+	delete(r.Files, strings.TrimPrefix(name, "/"))
+	return nil
+}
+
+func TestDryRunner(t *testing.T) {
+	var out bytes.Buffer
+	r := &DryRunner{Out: &out}
+	ctx := context.Background()
+	if err := r.Run(ctx, Cmd{
+		Name:  "apt-get",
+		Args:  []string{"install", "-y", "kubelet"},
+		Env:   []string{"DEBIAN_FRONTEND=noninteractive"},
+		Dir:   "/root",
+		Stdin: strings.NewReader("input"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(ctx, Cmd{Name: "kubeadm", Args: []string{"token", "create", "secret"}, Redact: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.MkdirAll("/etc/ki", 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WriteFile("/etc/ki/ki.yaml", []byte("kind: Cluster"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WriteFile("/usr/local/bin/ki", []byte{0xff, 0xfe}, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RemoveAll("/etc/ki/old"); err != nil {
+		t.Fatal(err)
+	}
+	const expected = `[dry-run] $ apt-get install -y kubelet
+[dry-run]   in /root
+[dry-run]   env DEBIAN_FRONTEND=noninteractive
+[dry-run]   stdin:
+input
+[dry-run] $ kubeadm <redacted>
+[dry-run] mkdir -p /etc/ki (-rwxr-x---)
+[dry-run] write /etc/ki/ki.yaml (-rw-------):
+kind: Cluster
+[dry-run] write /usr/local/bin/ki (-rwxr-xr-x):
+<2 bytes of binary data>
+[dry-run] rm -rf /etc/ki/old
+`
+	if got := out.String(); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestDisableSwap(t *testing.T) {
+	r := newRecordingRunner()
+	if err := r.WriteFile("/etc/fstab", []byte("UUID=1234 / ext4 defaults 0 1\n/swapfile none swap sw 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(r).DisableSwap(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := r.ReadFile("/etc/fstab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "UUID=1234 / ext4 defaults 0 1\n#ki: /swapfile none swap sw 0 0\n"; string(data) != expected {
+		t.Errorf("got fstab:\n%s\nexpected:\n%s", data, expected)
+	}
+	if expected := []string{"swapoff -a"}; !reflect.DeepEqual(r.Commands, expected) {
+		t.Errorf("got %v, expected %v", r.Commands, expected)
+	}
+
+	// Reset restores only lines commented by ki.
+	r.Commands = nil
+	restored, err := New(r).RestoreSwap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored {
+		t.Fatal("swap is not restored")
+	}
+	if data, _ = r.ReadFile("/etc/fstab"); string(data) != "UUID=1234 / ext4 defaults 0 1\n/swapfile none swap sw 0 0\n" {
+		t.Errorf("got fstab after restore:\n%s", data)
+	}
+	if expected := []string{"swapon -a"}; !reflect.DeepEqual(r.Commands, expected) {
+		t.Errorf("got %v, expected %v", r.Commands, expected)
+	}
+}
@@ -0,0 +1,96 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+const (
+	manifestsDir = "/etc/kubernetes/manifests"
+	// stoppedManifestsDir keeps manifests of stopped static pods, kubelet
+	// only watches manifestsDir.
+	stoppedManifestsDir = "/etc/kubernetes/ki-stopped"
+)
+
+// controlPlanePods are static pods of control plane node created by kubeadm.
+var controlPlanePods = []string{"etcd", "kube-apiserver", "kube-controller-manager", "kube-scheduler"}
+
+// waitContainersStopped waits until kubelet stops static pods.
+func (i *Installer) waitContainersStopped(ctx context.Context, names ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		running := false
+		for _, name := range names {
+			out, err := i.Runner.Output(ctx, Cmd{Name: "crictl", Args: []string{"ps", "--quiet", "--name", "^" + name + "$"}})
+			if err != nil {
+				return errors.Wrap(err, "crictl ps")
+			}
+			if len(bytes.TrimSpace(out)) > 0 {
+				running = true
+			}
+		}
+		if !running || i.dryRun() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "containers are still running")
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopStaticPods stops static pods by moving their manifests aside.
+func (i *Installer) stopStaticPods(ctx context.Context, names ...string) error {
+	if err := i.Runner.MkdirAll(stoppedManifestsDir, 0700); err != nil {
+		return errors.Wrap(err, "mkdir")
+	}
+	for _, name := range names {
+		if err := i.Runner.Run(ctx, Cmd{
+			Name: "mv",
+			Args: []string{path.Join(manifestsDir, name+".yaml"), stoppedManifestsDir},
+		}); err != nil {
+			return errors.Wrapf(err, "move %s manifest", name)
+		}
+	}
+	if err := i.waitContainersStopped(ctx, names...); err != nil {
+		return errors.Wrap(err, "wait")
+	}
+	return nil
+}
+
+// startStaticPods starts static pods stopped by stopStaticPods.
+func (i *Installer) startStaticPods(ctx context.Context, names ...string) error {
+	for _, name := range names {
+		if err := i.Runner.Run(ctx, Cmd{
+			Name: "mv",
+			Args: []string{path.Join(stoppedManifestsDir, name+".yaml"), manifestsDir},
+		}); err != nil {
+			return errors.Wrapf(err, "move %s manifest", name)
+		}
+	}
+	return nil
+}
+
+// restartStaticPods restarts static pods one by one, so they pick up
+// renewed certificates and kubeconfigs.
+func (i *Installer) restartStaticPods(ctx context.Context, names ...string) error {
+	for _, name := range names {
+		slog.Info("Restarting static pod", "name", name)
+		if err := i.stopStaticPods(ctx, name); err != nil {
+			return errors.Wrapf(err, "stop %s", name)
+		}
+		if err := i.startStaticPods(ctx, name); err != nil {
+			return errors.Wrapf(err, "start %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package install
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/go-faster/errors"
+)
+
+const (
+	RoleControlPlane = "control-plane"
+	RoleWorker       = "worker"
+	RoleNone         = "none"
+)
+
+// Status of node.
+type Status struct {
+	// Role of node in cluster, detected by kubeadm files.
+	Role string `json:"role"`
+	// Kubelet is kubelet service state reported by systemd.
+	Kubelet string `json:"kubelet"`
+	State   *State `json:"state"`
+}
+
+// Status inspects node.
+func (i *Installer) Status(ctx context.Context) (*Status, error) {
+	s := &Status{Role: RoleNone}
+	switch {
+	case i.exists(adminKubeconfig):
+		s.Role = RoleControlPlane
+	case i.exists(kubeletKubeconfig):
+		s.Role = RoleWorker
+	}
+	// Exit code is non-zero for inactive service, but state is still printed.
+	out, err := i.Runner.Output(ctx, Cmd{Name: "systemctl", Args: []string{"is-active", "kubelet"}})
+	s.Kubelet = string(bytes.TrimSpace(out))
+	if s.Kubelet == "" {
+		s.Kubelet = "unknown"
+		if err != nil {
+			s.Kubelet += " (" + err.Error() + ")"
+		}
+	}
+	state, err := i.LoadState()
+	if err != nil {
+		return nil, errors.Wrap(err, "load state")
+	}
+	s.State = state
+	return s, nil
+}
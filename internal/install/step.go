@@ -0,0 +1,216 @@
+package install
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// StatePath is path to file with install progress.
+const StatePath = "/var/lib/ki/state.json"
+
+// Step is named install step.
+type Step struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// State is persisted install progress.
+type State struct {
+	// Completed maps step name to completion time.
+	Completed map[string]time.Time `json:"completed"`
+	// Failed is name of the last failed step.
+	Failed string `json:"failed,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// LoadState reads install progress from StatePath.
+//
+// Missing file means that nothing is done yet.
+func (i *Installer) LoadState() (*State, error) {
+	s := &State{Completed: map[string]time.Time{}}
+	data, err := i.Runner.ReadFile(StatePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrap(err, "unmarshal")
+	}
+	if s.Completed == nil {
+		s.Completed = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+// SaveState writes install progress to StatePath.
+func (i *Installer) SaveState(s *State) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	if err := i.Runner.MkdirAll(filepath.Dir(StatePath), 0750); err != nil {
+		return errors.Wrap(err, "mkdir")
+	}
+	if err := i.Runner.WriteFile(StatePath, data, 0600); err != nil {
+		return errors.Wrap(err, "write")
+	}
+	return nil
+}
+
+// Initialized reports whether node is initialized or joined by ki.
+func (i *Installer) Initialized() (bool, error) {
+	state, err := i.LoadState()
+	if err != nil {
+		return false, errors.Wrap(err, "load state")
+	}
+	for _, name := range []string{"kubeadm-init", "kubeadm-join"} {
+		if _, ok := state.Completed[name]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type RunStepsOptions struct {
+	// FromStep forces running steps starting from named one,
+	// even if they are already completed.
+	FromStep string
+	// OnlyStep forces running only named step.
+	OnlyStep string
+	// Timeout limits duration of each step, zero means no limit.
+	Timeout time.Duration
+	// Timeouts overrides Timeout for named steps.
+	Timeouts map[string]time.Duration
+	// Retry is policy for network-dependent operations of each step,
+	// DefaultRetryPolicy is used if not set.
+	Retry *RetryPolicy
+	// Retries overrides Retry for named steps.
+	Retries map[string]RetryPolicy
+}
+
+func (o RunStepsOptions) timeout(name string) time.Duration {
+	if t, ok := o.Timeouts[name]; ok {
+		return t
+	}
+	return o.Timeout
+}
+
+func (o RunStepsOptions) retry(name string) RetryPolicy {
+	if p, ok := o.Retries[name]; ok {
+		return p
+	}
+	if o.Retry != nil {
+		return *o.Retry
+	}
+	return DefaultRetryPolicy
+}
+
+// runStep runs step with timeout and retry policy.
+func runStep(ctx context.Context, s Step, timeout time.Duration, p RetryPolicy) error {
+	ctx = WithRetryPolicy(ctx, p)
+	if timeout <= 0 {
+		return s.Run(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := s.Run(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.Wrapf(err, "timed out after %s", timeout)
+	}
+	return err
+}
+
+func stepNames(steps []Step) string {
+	names := make([]string, 0, len(steps))
+	for _, s := range steps {
+		names = append(names, s.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func findStep(steps []Step, name string) (int, error) {
+	for idx, s := range steps {
+		if s.Name == name {
+			return idx, nil
+		}
+	}
+	return 0, errors.Errorf("unknown step %q (available: %s)", name, stepNames(steps))
+}
+
+// RunSteps runs steps in order, skipping already completed ones
+// and recording progress in StatePath.
+func (i *Installer) RunSteps(ctx context.Context, steps []Step, opt RunStepsOptions) error {
+	if opt.FromStep != "" && opt.OnlyStep != "" {
+		return errors.New("from-step and only-step are mutually exclusive")
+	}
+	state, err := i.LoadState()
+	if err != nil {
+		return errors.Wrap(err, "load state")
+	}
+	from, to := 0, len(steps)
+	switch {
+	case opt.FromStep != "":
+		if from, err = findStep(steps, opt.FromStep); err != nil {
+			return err
+		}
+	case opt.OnlyStep != "":
+		if from, err = findStep(steps, opt.OnlyStep); err != nil {
+			return err
+		}
+		to = from + 1
+	}
+	force := opt.FromStep != "" || opt.OnlyStep != ""
+	for idx, s := range steps {
+		if idx < from || idx >= to {
+			continue
+		}
+		lg := slog.With("step", s.Name)
+		if _, done := state.Completed[s.Name]; done && !force {
+			lg.Info("Step is already completed, skipping")
+			i.Events.Emit(Event{Type: EventStepSkip, Step: s.Name})
+			continue
+		}
+		lg.Info("Step started")
+		i.Events.Emit(Event{Type: EventStepStart, Step: s.Name})
+		start := time.Now()
+		if err := runStep(ctx, s, opt.timeout(s.Name), opt.retry(s.Name)); err != nil {
+			duration := time.Since(start)
+			lg.Error("Step failed", "duration", duration, "error", err)
+			i.Events.Emit(Event{
+				Type:     EventStepFail,
+				Step:     s.Name,
+				Duration: duration.Seconds(),
+				Error:    err.Error(),
+			})
+			state.Failed = s.Name
+			state.Error = err.Error()
+			if saveErr := i.SaveState(state); saveErr != nil {
+				lg.Error("Failed to save state", "error", saveErr)
+			}
+			return errors.Wrapf(err, "step %s", s.Name)
+		}
+		duration := time.Since(start)
+		lg.Info("Step completed", "duration", duration)
+		i.Events.Emit(Event{
+			Type:     EventStepFinish,
+			Step:     s.Name,
+			Duration: duration.Seconds(),
+		})
+		state.Completed[s.Name] = time.Now().UTC()
+		state.Failed = ""
+		state.Error = ""
+		if err := i.SaveState(state); err != nil {
+			return errors.Wrap(err, "save state")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package install
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-faster/errors"
+)
+
+func TestRunSteps(t *testing.T) {
+	ctx := context.Background()
+	r := newRecordingRunner()
+	in := New(r)
+	var fail bool
+	step := func(name string) Step {
+		return Step{
+			Name: name,
+			Run: func(ctx context.Context) error {
+				if fail && name == "join" {
+					return errors.New("failed")
+				}
+				return in.Runner.Run(ctx, Cmd{Name: name})
+			},
+		}
+	}
+	steps := []Step{step("packages"), step("containerd"), step("join")}
+	run := func(opt RunStepsOptions) []string {
+		t.Helper()
+		r.Commands = nil
+		if err := in.RunSteps(ctx, steps, opt); err != nil {
+			t.Fatal(err)
+		}
+		return r.Commands
+	}
+	for _, tt := range []struct {
+		Name     string
+		Opt      RunStepsOptions
+		Expected []string
+	}{
+		{Name: "All", Expected: []string{"packages", "containerd", "join"}},
+		{Name: "Completed"},
+		{Name: "FromStep", Opt: RunStepsOptions{FromStep: "containerd"}, Expected: []string{"containerd", "join"}},
+		{Name: "OnlyStep", Opt: RunStepsOptions{OnlyStep: "containerd"}, Expected: []string{"containerd"}},
+	} {
+		if got := run(tt.Opt); !reflect.DeepEqual(got, tt.Expected) {
+			t.Errorf("%s: got %v, expected %v", tt.Name, got, tt.Expected)
+		}
+	}
+
+	// Failed step is recorded and retried on next run.
+	delete(r.Files, strings.TrimPrefix(StatePath, "/"))
+	fail = true
+	r.Commands = nil
+	if err := in.RunSteps(ctx, steps, RunStepsOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if expected := []string{"packages", "containerd"}; !reflect.DeepEqual(r.Commands, expected) {
+		t.Errorf("got %v, expected %v", r.Commands, expected)
+	}
+	state, err := in.LoadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Failed != "join" || state.Error != "failed" {
+		t.Errorf("unexpected failure in state: %q, %q", state.Failed, state.Error)
+	}
+	fail = false
+	if got, expected := run(RunStepsOptions{}), []string{"join"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+	if state, err = in.LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if state.Failed != "" || len(state.Completed) != 3 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	if err := in.RunSteps(ctx, steps, RunStepsOptions{OnlyStep: "missing"}); err == nil {
+		t.Error("expected error for unknown step")
+	}
+}
@@ -0,0 +1,321 @@
+package install
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/go-faster/errors"
+
+	"github.com/ernado/ki/internal/config"
+)
+
+type NodeOptions struct {
+	Distro Distro
+	Arch   string // amd64
+	Config config.Config
+}
+
+// NodeSteps returns steps that prepare any node, control plane or worker.
+func NodeSteps(in *Installer, opt NodeOptions) []Step {
+	cfg := opt.Config
+	distro, arch := opt.Distro, opt.Arch
+	return []Step{
+		{
+			Name: "install-helm",
+			Run: func(ctx context.Context) error {
+				sum, ok := cfg.Helm.SHA256[arch]
+				if !ok {
+					return errors.Errorf("helm.sha256.%s: checksum is not pinned for node architecture", arch)
+				}
+				return in.InstallBinary(ctx, Binary{
+					Name:   "helm",
+					URL:    "https://get.helm.sh/helm-" + cfg.Helm.Version + "-linux-" + arch + ".tar.gz",
+					SHA256: sum,
+				})
+			},
+		},
+		{
+			Name: "install-cilium-cli",
+			Run: func(ctx context.Context) error {
+				// https://github.com/cilium/cilium-cli/releases/
+				sum, ok := cfg.Cilium.CLI.SHA256[arch]
+				if !ok {
+					return errors.Errorf("cilium.cli.sha256.%s: checksum is not pinned for node architecture", arch)
+				}
+				return in.InstallBinary(ctx, Binary{
+					Name:   "cilium",
+					URL:    "https://github.com/cilium/cilium-cli/releases/download/" + cfg.Cilium.CLI.Version + "/cilium-linux-" + arch + ".tar.gz",
+					SHA256: sum,
+				})
+			},
+		},
+		{Name: "disable-swap", Run: in.DisableSwap},
+		{
+			Name: "apt-upgrade",
+			Run: func(ctx context.Context) error {
+				if err := in.APTUpdate(ctx); err != nil {
+					return errors.Wrap(err, "apt update")
+				}
+				return in.APTUpgrade(ctx)
+			},
+		},
+		{
+			// Installing a container runtime.
+			Name: "kernel-modules",
+			Run: func(ctx context.Context) error {
+				return in.LoadKernelModules(ctx, "containerd", cfg.Node.KernelModules...)
+			},
+		},
+		{
+			Name: "kernel-parameters",
+			Run: func(ctx context.Context) error {
+				params := make(map[string]any, len(cfg.Node.Sysctl))
+				for k, v := range cfg.Node.Sysctl {
+					params[k] = v
+				}
+				return in.ConfigureKernelParameters(ctx, "kubernetes", params)
+			},
+		},
+		{
+			Name: "containerd-dependencies",
+			Run: func(ctx context.Context) error {
+				slog.Info("Installing containerd")
+				return in.APTInstall(ctx, distro.Dependencies...)
+			},
+		},
+		{
+			Name: "docker-repo",
+			Run: func(ctx context.Context) error {
+				if err := in.APTKey(ctx, "docker", distro.DockerRepo+"/gpg"); err != nil {
+					return errors.Wrap(err, "add docker key")
+				}
+				if err := in.APTAddRepo(ctx, APTAddRepoOptions{
+					Name:       "docker",
+					URL:        distro.DockerRepo,
+					SignedBy:   aptKeyPath("docker"),
+					Arch:       []string{arch},
+					Components: []string{distro.Codename, "stable"},
+				}); err != nil {
+					return errors.Wrap(err, "add docker repo")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "install-containerd",
+			Run: func(ctx context.Context) error {
+				if err := in.APTUpdate(ctx); err != nil {
+					return errors.Wrap(err, "apt update")
+				}
+				return in.APTInstall(ctx, "curl", distro.Containerd)
+			},
+		},
+		{Name: "configure-containerd", Run: in.ConfigureContainerd},
+		{
+			Name: "k8s-repo",
+			Run: func(ctx context.Context) error {
+				slog.Info("Installing k8s")
+				return in.K8sRepo(ctx, cfg.Kubernetes.Version)
+			},
+		},
+		{
+			Name: "install-k8s",
+			Run: func(ctx context.Context) error {
+				if err := in.APTUpdate(ctx); err != nil {
+					return errors.Wrap(err, "apt update")
+				}
+				if err := in.APTInstall(ctx, k8sPackages...); err != nil {
+					return errors.Wrap(err, "install k8s")
+				}
+				if err := in.APTHold(ctx, k8sPackages...); err != nil {
+					return errors.Wrap(err, "hold k8s")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "start-kubelet",
+			Run: func(ctx context.Context) error {
+				slog.Info("Starting kubelet")
+				if err := in.Systemctl(ctx, "enable", "kubelet"); err != nil {
+					return errors.Wrap(err, "enable kubelet")
+				}
+				if err := in.Systemctl(ctx, "start", "kubelet"); err != nil {
+					return errors.Wrap(err, "start kubelet")
+				}
+				return nil
+			},
+		},
+	}
+}
+
+type JoinOptions struct {
+	ControlPlaneInternalIP string
+	// ControlPlane joins node as control plane instead of worker.
+	ControlPlane bool
+	Config       config.Config
+	// Node is identity of node from metadata service, optional.
+	Node *NodeIdentity
+	// AdvertiseAddress is private IP of joining control plane node.
+	AdvertiseAddress string
+}
+
+func kubeadmOverrides(cfg config.Config) KubeadmOverrides {
+	return KubeadmOverrides{
+		InitConfiguration:    cfg.Kubeadm.InitConfiguration,
+		ClusterConfiguration: cfg.Kubeadm.ClusterConfiguration,
+		KubeletConfiguration: cfg.Kubeadm.KubeletConfiguration,
+		JoinConfiguration:    cfg.Kubeadm.JoinConfiguration,
+	}
+}
+
+// JoinSteps returns steps that join worker or control plane node to cluster.
+func JoinSteps(in *Installer, opt JoinOptions) []Step {
+	joinSecretPath := config.JoinSecretPath
+	if opt.ControlPlane {
+		joinSecretPath = config.ControlPlaneJoinSecretPath
+	}
+	steps := []Step{
+		{
+			Name: "kubeadm-join",
+			Run: func(ctx context.Context) error {
+				if err := in.KubeadmJoin(ctx, KubeadmJoinOptions{
+					ControlPlaneInternalIP: opt.ControlPlaneInternalIP,
+					ControlPlane:           opt.ControlPlane,
+					Overrides:              kubeadmOverrides(opt.Config),
+					JoinServer:             opt.Config.JoinServerAddr(),
+					JoinFingerprint:        opt.Config.Join.Fingerprint,
+					JoinSecretPath:         joinSecretPath,
+					CACertHash:             opt.Config.Join.CACertHash,
+					BootstrapTokenPath:     config.BootstrapTokenPath,
+					Endpoint:               apiServerHost(opt.Config),
+					ClusterName:            opt.Config.Kubernetes.ClusterName,
+					KubernetesVersion:      opt.Config.Kubernetes.Version,
+					ExternalCloudProvider:  opt.Config.Addons.HetznerCCM,
+					Node:                   opt.Node,
+					AdvertiseAddress:       opt.AdvertiseAddress,
+				}); err != nil {
+					return err
+				}
+				slog.Info("Joined")
+				return nil
+			},
+		},
+	}
+	if opt.ControlPlane {
+		steps = append(steps, Step{Name: "setup-kubeconfig", Run: in.SetupKubeconfig})
+	}
+	return steps
+}
+
+// apiServerHost returns address of API server for joining nodes.
+func apiServerHost(cfg config.Config) string {
+	if e := cfg.ControlPlane.Endpoint; e != "" {
+		return e
+	}
+	return cfg.ControlPlane.InternalIP
+}
+
+// extraSans returns additional API server certificate names, public IP
+// is added only if set.
+func extraSans(cfg config.Config, publicIP string) []string {
+	sans := []string{cfg.ControlPlane.InternalIP}
+	if e := cfg.ControlPlane.Endpoint; e != "" && e != cfg.ControlPlane.InternalIP {
+		sans = append(sans, e)
+	}
+	if publicIP != "" {
+		sans = append(sans, publicIP)
+	}
+	return sans
+}
+
+type ControlPlaneOptions struct {
+	ControlPlaneEndpoint string
+	Config               config.Config
+	// Node is identity of node from metadata service, optional.
+	Node *NodeIdentity
+	// AdvertiseAddress is private IP of node.
+	AdvertiseAddress string
+	// PublicIP is added to API server certificate, optional.
+	PublicIP string
+}
+
+// ControlPlaneSteps returns steps that initialize cluster on control plane node.
+func ControlPlaneSteps(in *Installer, opt ControlPlaneOptions) []Step {
+	cfg := opt.Config
+	steps := []Step{
+		{
+			Name: "kubeadm-init",
+			Run: func(ctx context.Context) error {
+				slog.Info("Initializing k8s")
+				return in.KubeadmInit(ctx, KubeadmInitOptions{
+					ClusterName:           cfg.Kubernetes.ClusterName,
+					SkipPhases:            cfg.Kubernetes.SkipPhases,
+					PodNetworkCIDR:        cfg.Kubernetes.PodCIDR,
+					ServiceCIDR:           cfg.Kubernetes.ServiceCIDR,
+					ControlPlaneEndpoint:  opt.ControlPlaneEndpoint,
+					ExtraSans:             extraSans(cfg, opt.PublicIP),
+					UploadCerts:           cfg.ControlPlane.Count > 1,
+					Overrides:             kubeadmOverrides(cfg),
+					BootstrapTokenPath:    config.BootstrapTokenPath,
+					BootstrapTokenTTL:     time.Duration(cfg.Join.TokenTTL),
+					CACertPath:            config.CACertPath,
+					CAKeyPath:             config.CAKeyPath,
+					ExternalCloudProvider: cfg.Addons.HetznerCCM,
+					Node:                  opt.Node,
+					AdvertiseAddress:      opt.AdvertiseAddress,
+				})
+			},
+		},
+		{Name: "setup-kubeconfig", Run: in.SetupKubeconfig},
+		{Name: "join-server", Run: in.JoinService},
+	}
+	if cfg.Addons.ServiceMonitorCRD {
+		steps = append(steps, Step{
+			Name: "service-monitor-crd",
+			Run: func(ctx context.Context) error {
+				return in.KubectlApply(ctx, KubectlApplyOptions{
+					File: serviceMonitorCRD,
+				})
+			},
+		})
+	}
+	steps = append(steps, Step{
+		Name: "install-cilium",
+		Run: func(ctx context.Context) error {
+			slog.Info("Installing cilium")
+			if err := in.HelmAddRepo(ctx, "cilium", "https://helm.cilium.io"); err != nil {
+				return errors.Wrap(err, "helm add repo")
+			}
+			return in.CiliumInstall(ctx, CiliumInstallOptions{
+				Version:        cfg.Cilium.Version,
+				K8sServiceHost: opt.ControlPlaneEndpoint,
+			})
+		},
+	})
+	if cfg.Addons.DemoIngress {
+		steps = append(steps, Step{Name: "default-ingress", Run: in.DefaultIngress})
+	}
+	if cfg.Backup.S3.Bucket != "" {
+		steps = append(steps, Step{
+			Name: "etcd-backup",
+			Run: func(ctx context.Context) error {
+				return in.EtcdBackupTimer(ctx, cfg.Backup.Schedule)
+			},
+		})
+	}
+	steps = append(steps, Step{
+		Name: "hetzner-cloud",
+		Run: func(ctx context.Context) error {
+			return in.HetznerCloudInstall(ctx, HetznerCloudOptions{
+				TokenPath: cfg.Hetzner.TokenPath,
+				Network:   cfg.Hetzner.Network,
+				CSI:       cfg.Addons.HetznerCSI,
+				CCM:       cfg.Addons.HetznerCCM,
+				PodCIDR:   cfg.Kubernetes.PodCIDR,
+			})
+		},
+	})
+	return steps
+}
@@ -3,51 +3,97 @@ package install
 import (
 	"bufio"
 	"bytes"
-	"fmt"
-	"os"
-	"os/exec"
+	"context"
+	"log/slog"
 
 	"github.com/go-faster/errors"
 )
 
+const (
+	fstabPath = "/etc/fstab"
+	// swapDisabledPrefix marks fstab lines commented by DisableSwap,
+	// so RestoreSwap can uncomment only them.
+	swapDisabledPrefix = "#ki: "
+)
+
 // DisableSwap disables swap on node.
-func DisableSwap() error {
+func (i *Installer) DisableSwap(ctx context.Context) error {
 	{
 		// Update /etc/fstab.
-		fileName := "/etc/fstab"
-		data, err := os.ReadFile(fileName)
+		fileName := fstabPath
+		data, err := i.Runner.ReadFile(fileName)
 		if err != nil {
 			return errors.Wrap(err, "read")
 		}
 		// Replace line with / swap to # Swap disabled.
+		// Already commented lines are left as is.
 		scanner := bufio.NewScanner(bytes.NewReader(data))
 		targetString := []byte(" swap ")
-		if !bytes.Contains(data, targetString) {
-			fmt.Println("> Swap is not enabled")
-			return nil
-		}
-		fmt.Println("> Updating /etc/fstab")
-		var out []byte
+		var (
+			out     []byte
+			changed bool
+		)
 		for scanner.Scan() {
-			line := scanner.Text()
-			if len(line) > 0 && bytes.Contains(scanner.Bytes(), targetString) {
-				out = append(out, '#')
+			line := scanner.Bytes()
+			if len(line) > 0 && line[0] != '#' && bytes.Contains(line, targetString) {
+				out = append(out, swapDisabledPrefix...)
+				changed = true
 			}
 			out = append(out, line...)
 			out = append(out, '\n')
 		}
-		// Write back.
-		if err := os.WriteFile("/etc/fstab", out, 0600); err != nil {
-			return errors.Wrap(err, "write")
+		if changed {
+			// Write back.
+			slog.Info("Updating fstab", "path", fileName)
+			if err := i.Runner.WriteFile(fileName, out, 0600); err != nil {
+				return errors.Wrap(err, "write")
+			}
+		} else {
+			slog.Info("Swap is not enabled in fstab", "path", fileName)
 		}
 	}
 	{
-		// Disable swap.
-		fmt.Println("> Disabling swap")
-		cmd := exec.Command("swapoff", "-a")
-		if err := cmd.Run(); err != nil {
+		// Disable swap, no-op if it is already disabled.
+		slog.Info("Disabling swap")
+		if err := i.Runner.Run(ctx, Cmd{Name: "swapoff", Args: []string{"-a"}}); err != nil {
 			return errors.Wrap(err, "run")
 		}
 	}
 	return nil
 }
+
+// RestoreSwap uncomments fstab lines commented by DisableSwap and enables swap,
+// reporting whether anything was restored.
+//
+// Lines commented by administrator are left as is.
+func (i *Installer) RestoreSwap(ctx context.Context) (bool, error) {
+	data, err := i.Runner.ReadFile(fstabPath)
+	if err != nil {
+		return false, errors.Wrap(err, "read")
+	}
+	var (
+		out     []byte
+		changed bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if rest, ok := bytes.CutPrefix(line, []byte(swapDisabledPrefix)); ok {
+			line = rest
+			changed = true
+		}
+		out = append(out, line...)
+		out = append(out, '\n')
+	}
+	if !changed {
+		return false, nil
+	}
+	slog.Info("Restoring swap in fstab", "path", fstabPath)
+	if err := i.Runner.WriteFile(fstabPath, out, 0600); err != nil {
+		return false, errors.Wrap(err, "write")
+	}
+	if err := i.Runner.Run(ctx, Cmd{Name: "swapon", Args: []string{"-a"}}); err != nil {
+		return false, errors.Wrap(err, "swapon")
+	}
+	return true, nil
+}
@@ -0,0 +1,61 @@
+package install
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRestoreSwap(t *testing.T) {
+	const (
+		fstab = `# /etc/fstab: static file system information.
+# swap was on /dev/sda2 during installation
+UUID=1234 / ext4 defaults 0 1
+#ki: /swapfile none swap sw 0 0
+#/dev/sdb1 none swap sw 0 0
+##/dev/sdc1 none swap sw 0 0
+#UUID=5678 /data ext4 defaults 0 2
+`
+		expected = `# /etc/fstab: static file system information.
+# swap was on /dev/sda2 during installation
+UUID=1234 / ext4 defaults 0 1
+/swapfile none swap sw 0 0
+#/dev/sdb1 none swap sw 0 0
+##/dev/sdc1 none swap sw 0 0
+#UUID=5678 /data ext4 defaults 0 2
+`
+	)
+	r := newRecordingRunner()
+	if err := r.WriteFile(fstabPath, []byte(fstab), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in := New(r)
+	restored, err := in.RestoreSwap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored {
+		t.Fatal("swap is not restored")
+	}
+	data, err := r.ReadFile(fstabPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", data, expected)
+	}
+	if len(r.Commands) != 1 || r.Commands[0] != "swapon -a" {
+		t.Errorf("unexpected commands: %v", r.Commands)
+	}
+
+	// DisableSwap marks lines, so they are restored same way.
+	r.Commands = nil
+	if err := in.DisableSwap(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if restored, err = in.RestoreSwap(context.Background()); err != nil || !restored {
+		t.Fatalf("restored: %v, %v", restored, err)
+	}
+	if data, _ = r.ReadFile(fstabPath); string(data) != expected {
+		t.Errorf("got after disable and restore:\n%s\nexpected:\n%s", data, expected)
+	}
+}
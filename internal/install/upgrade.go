@@ -0,0 +1,228 @@
+package install
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/go-faster/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ernado/ki/internal/config"
+)
+
+// kubeAPIServerManifest exists only on control plane nodes.
+const kubeAPIServerManifest = "/etc/kubernetes/manifests/kube-apiserver.yaml"
+
+type UpgradeOptions struct {
+	// To is target minor version, like v1.32.
+	To string
+	// NodeName is name of this node in cluster.
+	NodeName string
+	// Kubeconfig with cluster admin access, used for drain and uncordon.
+	// Admin kubeconfig of control plane node is used if empty, workers
+	// have none and require it unless SkipDrain is set.
+	Kubeconfig string
+	// SkipDrain skips drain and uncordon of node.
+	SkipDrain bool
+}
+
+// kubectl returns kubectl command that uses kubeconfig.
+func kubectl(kubeconfig string, args ...string) Cmd {
+	return Cmd{
+		Name: "kubectl",
+		Args: append([]string{"--kubeconfig", kubeconfig}, args...),
+	}
+}
+
+// clusterVersion returns kubernetes version of cluster from kubeadm
+// configuration, like v1.31.5. It is updated by "kubeadm upgrade apply".
+//
+// Nodes are allowed to read kubeadm configuration, so kubelet
+// kubeconfig is enough.
+func (i *Installer) clusterVersion(ctx context.Context, kubeconfig string) (string, error) {
+	out, err := i.Runner.Output(ctx, kubectl(kubeconfig,
+		"get", "configmap", "kubeadm-config",
+		"--namespace", "kube-system",
+		"--output", "jsonpath={.data.ClusterConfiguration}",
+	))
+	if err != nil {
+		return "", errors.Wrap(err, "get kubeadm-config")
+	}
+	var cfg struct {
+		KubernetesVersion string `yaml:"kubernetesVersion"`
+	}
+	if err := yaml.Unmarshal(out, &cfg); err != nil {
+		return "", errors.Wrap(err, "unmarshal")
+	}
+	return cfg.KubernetesVersion, nil
+}
+
+// checkUpgradeSkew checks that node is upgraded one minor version at a
+// time, and that workers are not upgraded before control plane.
+func checkUpgradeSkew(node, cluster, to string, controlPlane bool) error {
+	nodeMinor, ok := config.MinorVersion(config.MajorMinor(node))
+	if !ok {
+		return errors.Errorf("invalid node version %q", node)
+	}
+	clusterMinor, ok := config.MinorVersion(config.MajorMinor(cluster))
+	if !ok {
+		return errors.Errorf("invalid cluster version %q", cluster)
+	}
+	toMinor, ok := config.MinorVersion(to)
+	if !ok {
+		return errors.Errorf("invalid target version %q, expected minor version like v1.32", to)
+	}
+	// Same minor is allowed to finish interrupted upgrade or upgrade patch version.
+	if toMinor < nodeMinor || toMinor > nodeMinor+1 {
+		return errors.Errorf("can't upgrade node from %s to %s, only one minor version at a time is supported", node, to)
+	}
+	if controlPlane {
+		if toMinor > clusterMinor+1 {
+			return errors.Errorf("can't upgrade control plane to %s, cluster is %s", to, cluster)
+		}
+		return nil
+	}
+	if toMinor > clusterMinor {
+		return errors.Errorf("can't upgrade worker to %s before control plane, cluster is %s", to, cluster)
+	}
+	return nil
+}
+
+// upgradePackages upgrades and holds packages from current k8s repository.
+func (i *Installer) upgradePackages(ctx context.Context, packages ...string) error {
+	if err := i.APTUnhold(ctx, packages...); err != nil {
+		return err
+	}
+	if err := i.APTInstall(ctx, packages...); err != nil {
+		return err
+	}
+	if err := i.APTHold(ctx, packages...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Upgrade upgrades kubernetes on node to next minor version.
+//
+// Control plane nodes should be upgraded first, one by one, then workers.
+// Upgrade can be safely run again if it was interrupted.
+func (i *Installer) Upgrade(ctx context.Context, opt UpgradeOptions) error {
+	controlPlane := i.exists(kubeAPIServerManifest)
+	nodeVersion, err := i.kubeadmVersion(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get node version")
+	}
+	// Kubelet is allowed to read kubeadm configuration on any node.
+	clusterVersion, err := i.clusterVersion(ctx, kubeletKubeconfig)
+	if err != nil {
+		return errors.Wrap(err, "get cluster version")
+	}
+	slog.Info("Upgrading node",
+		"node", opt.NodeName,
+		"control_plane", controlPlane,
+		"node_version", nodeVersion,
+		"cluster_version", clusterVersion,
+		"to", opt.To,
+	)
+	if i.dryRun() && (nodeVersion == "" || clusterVersion == "") {
+		slog.Warn("Skipping version skew check in dry run")
+	} else if err := checkUpgradeSkew(nodeVersion, clusterVersion, opt.To, controlPlane); err != nil {
+		return err
+	}
+	if !opt.SkipDrain {
+		switch {
+		case opt.Kubeconfig == "" && controlPlane:
+			opt.Kubeconfig = adminKubeconfig
+		case opt.Kubeconfig == "":
+			// Kubelet kubeconfig is not allowed to evict pods.
+			return errors.New("worker has no admin kubeconfig to drain itself: " +
+				"pass --kubeconfig with access to drain nodes, " +
+				"or drain worker from control plane and pass --skip-drain")
+		}
+		if !i.exists(opt.Kubeconfig) {
+			return errors.Errorf("kubeconfig %s for drain not found, pass --kubeconfig or --skip-drain", opt.Kubeconfig)
+		}
+	}
+
+	// 1. Upgrade kubeadm from repository of target version.
+	if err := i.K8sRepo(ctx, opt.To); err != nil {
+		return errors.Wrap(err, "k8s repo")
+	}
+	if err := i.APTUpdate(ctx); err != nil {
+		return errors.Wrap(err, "apt update")
+	}
+	if err := i.upgradePackages(ctx, "kubeadm"); err != nil {
+		return errors.Wrap(err, "upgrade kubeadm")
+	}
+	target, err := i.kubeadmVersion(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get kubeadm version")
+	}
+	if !i.dryRun() && config.MajorMinor(target) != opt.To {
+		return errors.Errorf("installed kubeadm %s is not %s", target, opt.To)
+	}
+
+	// 2. Upgrade control plane components or kubelet configuration.
+	//
+	// First control plane node upgrades cluster to new minor version,
+	// other nodes follow it, even if newer patch version is released meanwhile.
+	if controlPlane && config.MajorMinor(clusterVersion) != config.MajorMinor(target) {
+		slog.Info("kubeadm upgrade apply", "version", target)
+		if err := i.Runner.Run(ctx, Cmd{Name: "kubeadm", Args: []string{"upgrade", "plan", target}}); err != nil {
+			return errors.Wrap(err, "kubeadm upgrade plan")
+		}
+		if err := i.Runner.Run(ctx, Cmd{Name: "kubeadm", Args: []string{"upgrade", "apply", "--yes", target}}); err != nil {
+			return errors.Wrap(err, "kubeadm upgrade apply")
+		}
+	} else {
+		slog.Info("kubeadm upgrade node")
+		if err := i.Runner.Run(ctx, Cmd{Name: "kubeadm", Args: []string{"upgrade", "node"}}); err != nil {
+			return errors.Wrap(err, "kubeadm upgrade node")
+		}
+	}
+
+	// 3. Upgrade kubelet on drained node.
+	if !opt.SkipDrain {
+		slog.Info("Draining node", "node", opt.NodeName)
+		if err := i.Runner.Run(ctx, kubectl(opt.Kubeconfig,
+			"drain", opt.NodeName,
+			"--ignore-daemonsets",
+			"--delete-emptydir-data",
+			"--timeout", "10m",
+		)); err != nil {
+			return errors.Wrap(err, "drain")
+		}
+	}
+	if err := i.upgradePackages(ctx, "kubelet", "kubectl"); err != nil {
+		return errors.Wrap(err, "upgrade kubelet")
+	}
+	if err := i.Runner.Run(ctx, Cmd{Name: "systemctl", Args: []string{"daemon-reload"}}); err != nil {
+		return errors.Wrap(err, "daemon-reload")
+	}
+	if err := i.Systemctl(ctx, "restart", "kubelet"); err != nil {
+		return errors.Wrap(err, "restart kubelet")
+	}
+	if !opt.SkipDrain {
+		slog.Info("Uncordoning node", "node", opt.NodeName)
+		// API server may be unavailable right after kubelet restart on
+		// control plane node.
+		if err := retry(ctx, "uncordon", func() error {
+			return i.Runner.Run(ctx, kubectl(opt.Kubeconfig, "uncordon", opt.NodeName))
+		}); err != nil {
+			return errors.Wrap(err, "uncordon")
+		}
+	}
+	slog.Info("Upgraded node", "version", target)
+	return nil
+}
+
+// NodeName returns default name of node, same as kubelet uses.
+func NodeName() (string, error) {
+	name, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "hostname")
+	}
+	return strings.ToLower(name), nil
+}
@@ -0,0 +1,64 @@
+package install
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckUpgradeSkew(t *testing.T) {
+	for _, tt := range []struct {
+		Name         string
+		Node         string
+		Cluster      string
+		To           string
+		ControlPlane bool
+		Error        string
+	}{
+		{Name: "FirstControlPlane", Node: "v1.31.5", Cluster: "v1.31.5", To: "v1.32", ControlPlane: true},
+		{Name: "NextControlPlane", Node: "v1.31.5", Cluster: "v1.32.1", To: "v1.32", ControlPlane: true},
+		{Name: "Worker", Node: "v1.31.5", Cluster: "v1.32.1", To: "v1.32"},
+		{Name: "SameVersion", Node: "v1.32.1", Cluster: "v1.32.1", To: "v1.32", ControlPlane: true},
+		{Name: "SameVersionWorker", Node: "v1.32.1", Cluster: "v1.32.1", To: "v1.32"},
+		{Name: "SkipMinor", Node: "v1.31.5", Cluster: "v1.31.5", To: "v1.33", ControlPlane: true, Error: "one minor version at a time"},
+		{Name: "Downgrade", Node: "v1.32.1", Cluster: "v1.32.1", To: "v1.31", ControlPlane: true, Error: "one minor version at a time"},
+		{Name: "WorkerBeforeControlPlane", Node: "v1.31.5", Cluster: "v1.31.5", To: "v1.32", Error: "before control plane"},
+		{Name: "NodeAheadOfCluster", Node: "v1.32.1", Cluster: "v1.31.5", To: "v1.33", ControlPlane: true, Error: "cluster is v1.31.5"},
+		{Name: "WorkerAheadOfCluster", Node: "v1.32.1", Cluster: "v1.31.5", To: "v1.32", Error: "before control plane"},
+		{Name: "InvalidTarget", Node: "v1.31.5", Cluster: "v1.31.5", To: "v1.32.1", Error: "invalid target version"},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := checkUpgradeSkew(tt.Node, tt.Cluster, tt.To, tt.ControlPlane)
+			switch {
+			case tt.Error == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.Error != "" && (err == nil || !strings.Contains(err.Error(), tt.Error)):
+				t.Errorf("got error %v, expected %q", err, tt.Error)
+			}
+		})
+	}
+}
+
+func TestUpgradeWorkerKubeconfig(t *testing.T) {
+	r := newRecordingRunner()
+	r.Outputs = map[string]string{
+		"kubeadm version -o short": "v1.31.5",
+		"kubectl --kubeconfig /etc/kubernetes/kubelet.conf get configmap kubeadm-config --namespace kube-system --output jsonpath={.data.ClusterConfiguration}": "kubernetesVersion: v1.32.1\n",
+	}
+	in := New(r)
+	err := in.Upgrade(context.Background(), UpgradeOptions{To: "v1.32", NodeName: "worker-1"})
+	if err == nil || !strings.Contains(err.Error(), "--skip-drain") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Only versions are read, node is not changed.
+	for _, cmd := range r.Commands {
+		if !strings.HasPrefix(cmd, "kubeadm version") && !strings.HasPrefix(cmd, "kubectl --kubeconfig /etc/kubernetes/kubelet.conf get") {
+			t.Errorf("unexpected command: %s", cmd)
+		}
+	}
+
+	err = in.Upgrade(context.Background(), UpgradeOptions{To: "v1.32", NodeName: "worker-1", Kubeconfig: "/root/admin.conf"})
+	if err == nil || !strings.Contains(err.Error(), "/root/admin.conf") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Package metadata implements client for Hetzner Cloud metadata service,
+// that describes server to itself.
+package metadata
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-faster/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultURL is base URL of metadata service, available on every server.
+const DefaultURL = "http://169.254.169.254/hetzner/v1/metadata"
+
+// Client queries metadata service.
+type Client struct {
+	// BaseURL is DefaultURL if empty.
+	BaseURL string
+	// HTTPClient is http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Metadata of server.
+type Metadata struct {
+	// InstanceID is server ID.
+	InstanceID int64  `yaml:"instance-id"`
+	Hostname   string `yaml:"hostname"`
+	PublicIPv4 string `yaml:"public-ipv4"`
+	// Region is network zone, like eu-central.
+	Region string `yaml:"region"`
+	// AvailabilityZone is datacenter, like fsn1-dc14.
+	AvailabilityZone string           `yaml:"availability-zone"`
+	PrivateNetworks  []PrivateNetwork `yaml:"-"`
+}
+
+// PrivateNetwork is private network attached to server.
+type PrivateNetwork struct {
+	IP           string   `yaml:"ip"`
+	AliasIPs     []string `yaml:"alias_ips"`
+	InterfaceNum int      `yaml:"interface_num"`
+	MACAddress   string   `yaml:"mac_address"`
+	NetworkID    int64    `yaml:"network_id"`
+	NetworkName  string   `yaml:"network_name"`
+	// Network is IP range of network, like 10.0.0.0/16.
+	Network string `yaml:"network"`
+	// Subnet is IP range of subnet, like 10.0.1.0/24.
+	Subnet  string `yaml:"subnet"`
+	Gateway string `yaml:"gateway"`
+}
+
+// Location returns location of server, like fsn1.
+func (m Metadata) Location() string {
+	location, _, _ := strings.Cut(m.AvailabilityZone, "-")
+	return location
+}
+
+// ProviderID returns node provider ID, same as set by Hetzner Cloud
+// Controller Manager.
+func (m Metadata) ProviderID() string {
+	return "hcloud://" + strconv.FormatInt(m.InstanceID, 10)
+}
+
+// Labels returns well-known topology labels of node, same as set by
+// Hetzner Cloud Controller Manager.
+func (m Metadata) Labels() map[string]string {
+	labels := map[string]string{}
+	if m.AvailabilityZone != "" {
+		labels["topology.kubernetes.io/region"] = m.Location()
+		labels["topology.kubernetes.io/zone"] = m.AvailabilityZone
+	}
+	return labels
+}
+
+// PrivateNetwork returns private network by name, or first one if name
+// is empty.
+func (m Metadata) PrivateNetwork(name string) (PrivateNetwork, bool) {
+	for _, n := range m.PrivateNetworks {
+		if name == "" || n.NetworkName == name {
+			return n, true
+		}
+	}
+	return PrivateNetwork{}, false
+}
+
+func (c *Client) get(ctx context.Context, path string, v any) error {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+path, nil)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "do")
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("GET %s: bad status: %s", req.URL, res.Status)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "read body")
+	}
+	if err := yaml.Unmarshal(data, v); err != nil {
+		return errors.Wrap(err, "unmarshal")
+	}
+	return nil
+}
+
+// Get returns metadata of server, including private networks.
+func (c *Client) Get(ctx context.Context) (*Metadata, error) {
+	var m Metadata
+	if err := c.get(ctx, "", &m); err != nil {
+		return nil, errors.Wrap(err, "metadata")
+	}
+	if err := c.get(ctx, "/private-networks", &m.PrivateNetworks); err != nil {
+		return nil, errors.Wrap(err, "private networks")
+	}
+	return &m, nil
+}
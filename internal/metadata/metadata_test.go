@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// Responses are shortened from real server.
+const (
+	testMetadata = `availability-zone: fsn1-dc14
+hostname: ki-control-plane-0
+instance-id: 42
+local-ipv4: ''
+public-ipv4: 203.0.113.1
+region: eu-central
+public-keys: []
+vendor_data: ''
+`
+	testPrivateNetworks = `- ip: 10.0.1.2
+  alias_ips: []
+  interface_num: 1
+  mac_address: 86:00:00:2a:7d:e0
+  network_id: 1234
+  network_name: ki
+  network: 10.0.0.0/16
+  subnet: 10.0.1.0/24
+  gateway: 10.0.0.1
+- ip: 10.1.0.5
+  alias_ips: [10.1.0.6]
+  interface_num: 2
+  mac_address: 86:00:00:2a:7d:e1
+  network_id: 5678
+  network_name: other
+  network: 10.1.0.0/16
+  subnet: 10.1.0.0/24
+  gateway: 10.1.0.1
+`
+)
+
+func TestClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /hetzner/v1/metadata", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, testMetadata)
+	})
+	mux.HandleFunc("GET /hetzner/v1/metadata/private-networks", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, testPrivateNetworks)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL + "/hetzner/v1/metadata/", HTTPClient: srv.Client()}
+	m, err := c.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Metadata{
+		InstanceID:       42,
+		Hostname:         "ki-control-plane-0",
+		PublicIPv4:       "203.0.113.1",
+		Region:           "eu-central",
+		AvailabilityZone: "fsn1-dc14",
+		PrivateNetworks: []PrivateNetwork{
+			{
+				IP:           "10.0.1.2",
+				AliasIPs:     []string{},
+				InterfaceNum: 1,
+				MACAddress:   "86:00:00:2a:7d:e0",
+				NetworkID:    1234,
+				NetworkName:  "ki",
+				Network:      "10.0.0.0/16",
+				Subnet:       "10.0.1.0/24",
+				Gateway:      "10.0.0.1",
+			},
+			{
+				IP:           "10.1.0.5",
+				AliasIPs:     []string{"10.1.0.6"},
+				InterfaceNum: 2,
+				MACAddress:   "86:00:00:2a:7d:e1",
+				NetworkID:    5678,
+				NetworkName:  "other",
+				Network:      "10.1.0.0/16",
+				Subnet:       "10.1.0.0/24",
+				Gateway:      "10.1.0.1",
+			},
+		},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("got %+v, expected %+v", m, expected)
+	}
+	if got := m.Location(); got != "fsn1" {
+		t.Errorf("location: got %s", got)
+	}
+	if got := m.ProviderID(); got != "hcloud://42" {
+		t.Errorf("provider ID: got %s", got)
+	}
+	expectedLabels := map[string]string{
+		"topology.kubernetes.io/region": "fsn1",
+		"topology.kubernetes.io/zone":   "fsn1-dc14",
+	}
+	if got := m.Labels(); !reflect.DeepEqual(got, expectedLabels) {
+		t.Errorf("labels: got %v, expected %v", got, expectedLabels)
+	}
+	for name, ip := range map[string]string{
+		"":      "10.0.1.2",
+		"ki":    "10.0.1.2",
+		"other": "10.1.0.5",
+	} {
+		n, ok := m.PrivateNetwork(name)
+		if !ok || n.IP != ip {
+			t.Errorf("private network %q: got %s, %v", name, n.IP, ok)
+		}
+	}
+	if _, ok := m.PrivateNetwork("missing"); ok {
+		t.Error("unexpected missing network")
+	}
+}
+
+func TestClientError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL, HTTPClient: srv.Client()}
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
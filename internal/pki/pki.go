@@ -0,0 +1,154 @@
+// Package pki implements certificates, keys and tokens used by ki.
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// Fingerprint returns SHA-256 hash of certificate public key (SPKI)
+// in "sha256:<hex>" format, same as kubeadm --discovery-token-ca-cert-hash.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// CertificateFingerprint returns SHA-256 hash of whole certificate in
+// "sha256:<hex>" format, same digest as "openssl x509 -fingerprint -sha256".
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ParseCertificate parses first PEM-encoded certificate.
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse")
+		}
+		return cert, nil
+	}
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// NewServingCert generates self-signed serving certificate for IP addresses
+// or DNS names, returning PEM-encoded certificate and key.
+func NewServingCert(commonName string, hosts []string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "generate key")
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "serial number")
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create certificate")
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "marshal key")
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// NewSecret generates random hex-encoded secret.
+func NewSecret() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", errors.Wrap(err, "read random")
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// NewCA generates self-signed cluster CA, returning PEM-encoded certificate
+// and key in format of kubeadm, so it can be placed to /etc/kubernetes/pki.
+func NewCA(commonName string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	// Same as default key of kubeadm.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "generate key")
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "serial number")
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create certificate")
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+// tokenAlphabet is alphabet of bootstrap tokens.
+const tokenAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// NewBootstrapToken generates kubeadm bootstrap token like abcdef.0123456789abcdef.
+func NewBootstrapToken() (string, error) {
+	b := make([]byte, 6+16)
+	size := big.NewInt(int64(len(tokenAlphabet)))
+	for j := range b {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", errors.Wrap(err, "read random")
+		}
+		b[j] = tokenAlphabet[n.Int64()]
+	}
+	return string(b[:6]) + "." + string(b[6:]), nil
+}
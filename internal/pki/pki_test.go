@@ -0,0 +1,106 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// testCA is self-signed CA, hashes are computed with openssl:
+//
+//	openssl x509 -pubkey -noout -in ca.crt | openssl pkey -pubin -outform der | openssl dgst -sha256 -hex
+//	openssl x509 -noout -fingerprint -sha256 -in ca.crt
+const testCA = `-----BEGIN CERTIFICATE-----
+MIIBkTCCATegAwIBAgIUftpQ9h3sUXS3oHeRd6G871SKWXMwCgYIKoZIzj0EAwIw
+FTETMBEGA1UEAwwKa3ViZXJuZXRlczAgFw0yNjEwMTgwMzU2MzJaGA8yMTI2MDky
+NDAzNTYzMlowFTETMBEGA1UEAwwKa3ViZXJuZXRlczBZMBMGByqGSM49AgEGCCqG
+SM49AwEHA0IABHUze802QEo3AzUE7j/H3rS0GAhW4h9pITJXiw7YLma//tWONwnu
+drQ8nHa85uWhylANPn0uivz9pU5Aa21KdNejYzBhMB0GA1UdDgQWBBT5L+at72Qb
+j67Qv5PDRGxj+Y2EJDAfBgNVHSMEGDAWgBT5L+at72Qbj67Qv5PDRGxj+Y2EJDAP
+BgNVHRMBAf8EBTADAQH/MA4GA1UdDwEB/wQEAwIChDAKBggqhkjOPQQDAgNIADBF
+AiBrKzXrT5SlsdsWPn+dxNd6ww7mUvrxi2GXY24M/r3RwwIhAO+v4+g3FSlqQ5ZD
+iTTDwFlo3//WfMwrSKMe/GyhnYM7
+-----END CERTIFICATE-----
+`
+
+func TestFingerprint(t *testing.T) {
+	cert, err := ParseCertificate([]byte(testCA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := Fingerprint(cert), "sha256:83934fc1f4d712410fb84b1f63c6abf763a7547a6e14012b5d5df53bc3f0bc8c"; got != expected {
+		t.Errorf("got %s, expected %s", got, expected)
+	}
+	if got, expected := CertificateFingerprint(cert), "sha256:97ae767961d04a41b902015e94924d7a1a0b83d34720936c380b03adb4e5605e"; got != expected {
+		t.Errorf("got %s, expected %s", got, expected)
+	}
+}
+
+func TestNewCA(t *testing.T) {
+	certPEM, keyPEM, err := NewCA("kubernetes", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(keyPEM); block == nil || block.Type != "RSA PRIVATE KEY" {
+		t.Error("key is not PKCS #1 as expected by kubeadm")
+	}
+	ca, err := ParseCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.IsCA || ca.Subject.CommonName != "kubernetes" {
+		t.Errorf("unexpected CA: IsCA %v, CN %q", ca.IsCA, ca.Subject.CommonName)
+	}
+
+	// Certificate issued by CA is verified with it.
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kube-apiserver"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"kubernetes"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, ca.PublicKey, pair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "kubernetes"}); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+}
+
+func TestNewBootstrapToken(t *testing.T) {
+	re := regexp.MustCompile(`^[a-z0-9]{6}\.[a-z0-9]{16}$`)
+	seen := map[string]bool{}
+	for range 10 {
+		token, err := NewBootstrapToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !re.MatchString(token) {
+			t.Errorf("token %q does not match %s", token, re)
+		}
+		if seen[token] {
+			t.Errorf("duplicate token %q", token)
+		}
+		seen[token] = true
+	}
+}
@@ -1,14 +1,33 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/ernado/ki/internal/install"
+	"github.com/ernado/ki/internal/cli"
+)
+
+// Set by goreleaser.
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
 )
 
 func main() {
-	if err := install.Run(); err != nil {
+	// Cancelling on signal, so running commands are terminated
+	// and install state is saved before exit.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cli.Run(ctx, cli.Build{
+		Version: version,
+		Commit:  commit,
+		Date:    date,
+	}, os.Args[1:]); err != nil {
+		stop()
 		_, _ = fmt.Fprintf(os.Stderr, "Error: %+v\n", err)
 		os.Exit(1)
 	}